@@ -19,9 +19,29 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/.well-known/jwks.json": {
+            "get": {
+                "description": "Returns the public keys of the RS256 and EdDSA keys that minted tokens are signed with,\npicked by the kid header of a token. HS256 keys are never published.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "Token public keys",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.JWKS"
+                        }
+                    }
+                }
+            }
+        },
         "/resource/": {
             "post": {
-                "description": "Receives torrent or magnet-uri in request body.\nIf magnet-uri provided instead of torrent, then it tries to fetch torrent from BitTorrent network (timeout 3 minutes).",
+                "description": "Receives torrent or magnet-uri in request body.\nIf magnet-uri provided instead of torrent, then it tries to fetch torrent from BitTorrent network (timeout 3 minutes).\nWith async=true it returns 202 right away with a job to poll at /resource/jobs/{job_id}.",
                 "consumes": [
                     "*/*"
                 ],
@@ -33,6 +53,12 @@ const docTemplate = `{
                 ],
                 "summary": "Stores resource",
                 "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "resolve in background",
+                        "name": "async",
+                        "in": "query"
+                    },
                     {
                         "example": "\"magnet:?xt=urn:btih:08ada5a7a6183aae1e09d831df6748d566095a10\u0026dn=Sintel\u0026tr=udp%3A%2F%2Ftracker.leechers-paradise.org%3A6969\u0026tr=udp%3A%2F%2Ftracker.coppersurfer.tk%3A6969\u0026tr=udp%3A%2F%2Ftracker.opentrackr.org%3A1337\u0026tr=udp%3A%2F%2Fexplodie.org%3A6969\u0026tr=udp%3A%2F%2Ftracker.empire-js.us%3A1337\u0026tr=wss%3A%2F%2Ftracker.btorrent.xyz\u0026tr=wss%3A%2F%2Ftracker.openwebtorrent.com\u0026tr=wss%3A%2F%2Ftracker.fastcast.nz\u0026ws=https%3A%2F%2Fwebtorrent.io%2Ftorrents%2F\"",
                         "description": "resource",
@@ -51,6 +77,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/services.ResourceResponse"
                         }
                     },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/services.ResourceJobResponse"
+                        }
+                    },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
@@ -69,8 +101,129 @@ const docTemplate = `{
                             "$ref": "#/definitions/services.ErrorResponse"
                         }
                     },
-                    "408": {
-                        "description": "Request Timeout",
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "504": {
+                        "description": "Gateway Timeout",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/batch": {
+            "post": {
+                "description": "Receives JSON array of magnet-uris, infohashes and base64-encoded torrents.\nEvery item is resolved concurrently, the response keeps the order of the request.\nEach item carries the status code it would get from POST /resource/.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Stores multiple resources",
+                "parameters": [
+                    {
+                        "description": "resources",
+                        "name": "resources",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/services.BatchResourceItem"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/jobs/{job_id}": {
+            "get": {
+                "description": "Receives job id returned by POST /resource/?async=true and reports its state.\nJob id is the infohash of the submitted resource.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "resource"
+                ],
+                "summary": "Returns resource job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"08ada5a7a6183aae1e09d831df6748d566095a10\"",
+                        "description": "job_id",
+                        "name": "job_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ResourceJobResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
                         "schema": {
                             "$ref": "#/definitions/services.ErrorResponse"
                         }
@@ -126,6 +279,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/services.ErrorResponse"
                         }
                     },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -151,18 +310,683 @@ const docTemplate = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "example": "\"08ada5a7a6183aae1e09d831df6748d566095a10\"",
-                        "description": "resource_id",
-                        "name": "resource_id",
-                        "in": "path",
-                        "required": true
+                        "example": "\"08ada5a7a6183aae1e09d831df6748d566095a10\"",
+                        "description": "resource_id",
+                        "name": "resource_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ResourceResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{resource_id}/content": {
+            "get": {
+                "description": "Same as /content/{content_id}, with the content selected by\nits path inside the torrent. Unicode NFC and NFD forms of the\npath both match.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "list"
+                ],
+                "summary": "Returns resource content by path",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"08ada5a7a6183aae1e09d831df6748d566095a10\"",
+                        "description": "resource_id",
+                        "name": "resource_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "example": "\"/Sintel/Sintel.mp4\"",
+                        "description": "content path",
+                        "name": "path",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ListItem"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{resource_id}/content/{content_id}": {
+            "get": {
+                "description": "Returns the file or directory item by content_id, with size\nand files count for directories. No urls are built, see\nexport for that. content_id is either the SHA1 of the\ncontent path (returned by /list) or the file's index in the\ntorrent's natural file order.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "list"
+                ],
+                "summary": "Returns resource content",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"08ada5a7a6183aae1e09d831df6748d566095a10\"",
+                        "description": "resource_id",
+                        "name": "resource_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "example": "\"ca2453df3e7691c28934eebed5a253ee0aabd29f\"",
+                        "description": "content_id",
+                        "name": "content_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ListItem"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{resource_id}/export": {
+            "get": {
+                "description": "Same as /export/{content_id}, with the content selected by\nits path inside the torrent. Unicode NFC and NFD forms of the\npath both match.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "export"
+                ],
+                "summary": "Exports resource content by path",
+                "parameters": [
+                    {
+                        "enum": [
+                            "download",
+                            "stream",
+                            "torrent_client_stat",
+                            "subtitles",
+                            "media_probe",
+                            "playlist",
+                            "preview"
+                        ],
+                        "type": "string",
+                        "description": "output",
+                        "name": "output",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "zip",
+                            "tar"
+                        ],
+                        "type": "string",
+                        "default": "zip",
+                        "description": "archive format for directory downloads",
+                        "name": "archive-format",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "m3u8",
+                            "xspf"
+                        ],
+                        "type": "string",
+                        "default": "m3u8",
+                        "description": "playlist format of directories, with output=playlist",
+                        "name": "playlist-format",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "hls",
+                            "dash"
+                        ],
+                        "type": "string",
+                        "default": "hls",
+                        "description": "adaptive streaming format of stream urls, html tags carry both",
+                        "name": "stream-format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "en,fr",
+                        "description": "preferred subtitle languages, comma-separated, the default track is picked by it or by Accept-Language",
+                        "name": "lang",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 30,
+                        "description": "position of the video thumbnail in seconds",
+                        "name": "preview-at",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "width of the preview in pixels",
+                        "name": "preview-width",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "limit directory archive to selected file/folder paths (repeatable)",
+                        "name": "paths",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "\"08ada5a7a6183aae1e09d831df6748d566095a10\"",
+                        "description": "resource_id",
+                        "name": "resource_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "example": "\"/Sintel/Sintel.mp4\"",
+                        "description": "content path",
+                        "name": "path",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ExportResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Provides urls for exporting several contents of one resource\nat once. The resource is loaded once, the request token is\nparsed once and cache probes are shared between items.\nWith recursive=true a directory content_id expands to every\nfile under it. Each item carries the status code it would get\nfrom GET /export/{content_id}, the response keeps the order of\nthe request.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "export"
+                ],
+                "summary": "Exports multiple resource contents",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "download,stream",
+                        "description": "comma-separated export types",
+                        "name": "types",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "hls",
+                            "dash"
+                        ],
+                        "type": "string",
+                        "default": "hls",
+                        "description": "adaptive streaming format of stream urls",
+                        "name": "stream-format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "\"08ada5a7a6183aae1e09d831df6748d566095a10\"",
+                        "description": "resource_id",
+                        "name": "resource_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "content ids",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/services.ExportBatchRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/services.ExportBatchItem"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{resource_id}/export/{content_id}": {
+            "get": {
+                "description": "Provides url for exporting resource content. content_id is\neither the SHA1 of the file's path (returned by /list) or\nthe file's index in the torrent's natural file order\n(matches the fileIdx convention used by Stremio addons).",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "export"
+                ],
+                "summary": "Exports resource content",
+                "parameters": [
+                    {
+                        "enum": [
+                            "download",
+                            "stream",
+                            "torrent_client_stat",
+                            "subtitles",
+                            "media_probe",
+                            "playlist",
+                            "preview"
+                        ],
+                        "type": "string",
+                        "description": "output",
+                        "name": "output",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "zip",
+                            "tar"
+                        ],
+                        "type": "string",
+                        "default": "zip",
+                        "description": "archive format for directory downloads",
+                        "name": "archive-format",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "m3u8",
+                            "xspf"
+                        ],
+                        "type": "string",
+                        "default": "m3u8",
+                        "description": "playlist format of directories, with output=playlist",
+                        "name": "playlist-format",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "hls",
+                            "dash"
+                        ],
+                        "type": "string",
+                        "default": "hls",
+                        "description": "adaptive streaming format of stream urls, html tags carry both",
+                        "name": "stream-format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "en,fr",
+                        "description": "preferred subtitle languages, comma-separated, the default track is picked by it or by Accept-Language",
+                        "name": "lang",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 30,
+                        "description": "position of the video thumbnail in seconds",
+                        "name": "preview-at",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "width of the preview in pixels",
+                        "name": "preview-width",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "limit directory archive to selected file/folder paths (repeatable)",
+                        "name": "paths",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "example": "\"08ada5a7a6183aae1e09d831df6748d566095a10\"",
+                        "description": "resource_id",
+                        "name": "resource_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "example": "\"ca2453df3e7691c28934eebed5a253ee0aabd29f\"",
+                        "description": "content_id",
+                        "name": "content_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ExportResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/resource/{resource_id}/list": {
+            "get": {
+                "description": "Lists files and directories of specific resource.\nAll ids in response can be used for export.",
+                "consumes": [
+                    "*/*"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "list"
+                ],
+                "summary": "Lists resource",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "example": "\"08ada5a7a6183aae1e09d831df6748d566095a10\"",
+                        "description": "resource_id",
+                        "name": "resource_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "directory path, matched regardless of Unicode normalization form",
+                        "name": "path",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "limit, up to 1000, or up to 10000 with cursor",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "offset",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page, must be used with the same output, sort, order, path and filters",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "list",
+                            "tree"
+                        ],
+                        "type": "string",
+                        "description": "output",
+                        "name": "output",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "name",
+                            "size",
+                            "index",
+                            "ext",
+                            "media_format"
+                        ],
+                        "type": "string",
+                        "description": "sort, folders go first; name is natural (Episode 2 before Episode 10), index keeps torrent order",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "description": "sort order, asc by default except for size",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "filter files by name, case-insensitive substring or glob (*, ?, [...])",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "enum": [
+                                "video",
+                                "audio",
+                                "image",
+                                "subtitle"
+                            ],
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "filter files by media format, comma-separated",
+                        "name": "media_format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "csv",
+                        "description": "filter files by extension, comma-separated",
+                        "name": "ext",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/services.ResourceResponse"
+                            "$ref": "#/definitions/services.ListResponse"
                         }
                     },
                     "400": {
@@ -177,6 +1001,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/services.ErrorResponse"
                         }
                     },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -186,52 +1016,30 @@ const docTemplate = `{
                 }
             }
         },
-        "/resource/{resource_id}/export/{content_id}": {
+        "/resource/{resource_id}/playlist/{content_id}": {
             "get": {
-                "description": "Provides url for exporting resource content. content_id is\neither the SHA1 of the file's path (returned by /list) or\nthe file's index in the torrent's natural file order\n(matches the fileIdx convention used by Stremio addons).",
+                "description": "Returns an M3U8 or XSPF playlist of every video and audio file\nunder the directory, each entry pointing to the file's stream\nurl. Meant to be opened right away in a player like VLC; the\nsame body is available as the playlist export type.",
                 "consumes": [
                     "*/*"
                 ],
                 "produces": [
-                    "application/json"
+                    "audio/x-mpegurl",
+                    "application/xspf+xml"
                 ],
                 "tags": [
                     "export"
                 ],
-                "summary": "Exports resource content",
+                "summary": "Returns playlist of directory",
                 "parameters": [
                     {
                         "enum": [
-                            "download",
-                            "stream",
-                            "torrent_client_stat",
-                            "subtitles",
-                            "media_probe"
-                        ],
-                        "type": "string",
-                        "description": "output",
-                        "name": "output",
-                        "in": "query"
-                    },
-                    {
-                        "enum": [
-                            "zip",
-                            "tar"
+                            "m3u8",
+                            "xspf"
                         ],
                         "type": "string",
-                        "default": "zip",
-                        "description": "archive format for directory downloads",
-                        "name": "archive-format",
-                        "in": "query"
-                    },
-                    {
-                        "type": "array",
-                        "items": {
-                            "type": "string"
-                        },
-                        "collectionFormat": "csv",
-                        "description": "limit directory archive to selected file/folder paths (repeatable)",
-                        "name": "paths",
+                        "default": "m3u8",
+                        "description": "playlist format",
+                        "name": "playlist-format",
                         "in": "query"
                     },
                     {
@@ -244,7 +1052,7 @@ const docTemplate = `{
                     },
                     {
                         "type": "string",
-                        "example": "\"ca2453df3e7691c28934eebed5a253ee0aabd29f\"",
+                        "example": "\"de1524300a82e6ec64511dd7ba9765dc85abeac0\"",
                         "description": "content_id",
                         "name": "content_id",
                         "in": "path",
@@ -255,7 +1063,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/services.ExportResponse"
+                            "type": "string"
                         }
                     },
                     "400": {
@@ -264,12 +1072,24 @@ const docTemplate = `{
                             "$ref": "#/definitions/services.ErrorResponse"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/services.ErrorResponse"
                         }
                     },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/services.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -279,19 +1099,19 @@ const docTemplate = `{
                 }
             }
         },
-        "/resource/{resource_id}/list": {
+        "/resource/{resource_id}/resolve/events": {
             "get": {
-                "description": "Lists files and directories of specific resource.\nAll ids in response can be used for export.",
+                "description": "Server-Sent Events stream following resolution of the resource: joins the job\nstarted with POST /resource/?async=true, or resolves the resource from the store.\nEvents are named after the stage (queued, fetching_metadata, pushed, ready, failed).\nThe stream ends with a ready event carrying ResourceResponse or a failed event carrying ErrorResponse.",
                 "consumes": [
                     "*/*"
                 ],
                 "produces": [
-                    "application/json"
+                    "text/event-stream"
                 ],
                 "tags": [
-                    "list"
+                    "resource"
                 ],
-                "summary": "Lists resource",
+                "summary": "Streams resource resolution progress",
                 "parameters": [
                     {
                         "type": "string",
@@ -300,52 +1120,13 @@ const docTemplate = `{
                         "name": "resource_id",
                         "in": "path",
                         "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "path",
-                        "name": "path",
-                        "in": "query"
-                    },
-                    {
-                        "type": "integer",
-                        "description": "limit",
-                        "name": "limit",
-                        "in": "query"
-                    },
-                    {
-                        "type": "integer",
-                        "description": "offset",
-                        "name": "offset",
-                        "in": "query"
-                    },
-                    {
-                        "enum": [
-                            "list",
-                            "tree"
-                        ],
-                        "type": "string",
-                        "description": "output",
-                        "name": "output",
-                        "in": "query"
-                    },
-                    {
-                        "enum": [
-                            "name",
-                            "size"
-                        ],
-                        "type": "string",
-                        "default": "name",
-                        "description": "sort",
-                        "name": "sort",
-                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/services.ListResponse"
+                            "$ref": "#/definitions/services.ResourceEvent"
                         }
                     },
                     "400": {
@@ -354,8 +1135,8 @@ const docTemplate = `{
                             "$ref": "#/definitions/services.ErrorResponse"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "429": {
+                        "description": "Too Many Requests",
                         "schema": {
                             "$ref": "#/definitions/services.ErrorResponse"
                         }
@@ -371,11 +1152,92 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "services.BatchResourceItem": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "$ref": "#/definitions/services.ErrorResponse"
+                },
+                "resource": {
+                    "$ref": "#/definitions/services.ResourceResponse"
+                },
+                "status": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.ErrorCode": {
+            "type": "string",
+            "enum": [
+                "bad_request",
+                "unauthorized",
+                "invalid_token",
+                "forbidden",
+                "not_found",
+                "quota_exceeded",
+                "rate_limited",
+                "upstream_timeout",
+                "upstream_unavailable",
+                "internal"
+            ],
+            "x-enum-varnames": [
+                "ErrorCodeBadRequest",
+                "ErrorCodeUnauthorized",
+                "ErrorCodeInvalidToken",
+                "ErrorCodeForbidden",
+                "ErrorCodeNotFound",
+                "ErrorCodeQuotaExceeded",
+                "ErrorCodeRateLimited",
+                "ErrorCodeUpstreamTimeout",
+                "ErrorCodeUpstreamUnavailable",
+                "ErrorCodeInternal"
+            ]
+        },
         "services.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "Code is the stable machine-readable kind of the error (bad_request,\nunauthorized, invalid_token, forbidden, not_found, quota_exceeded,\nrate_limited, upstream_timeout, upstream_unavailable, internal).\nUnlike Error it never changes with the wording.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/services.ErrorCode"
+                        }
+                    ]
+                },
+                "error": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.ExportBatchItem": {
             "type": "object",
             "properties": {
                 "error": {
+                    "$ref": "#/definitions/services.ErrorResponse"
+                },
+                "export": {
+                    "$ref": "#/definitions/services.ExportResponse"
+                },
+                "id": {
                     "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.ExportBatchRequest": {
+            "type": "object",
+            "properties": {
+                "content_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "recursive": {
+                    "description": "Recursive expands directory content ids to every file under them.",
+                    "type": "boolean"
                 }
             }
         },
@@ -388,6 +1250,9 @@ const docTemplate = `{
                 "meta": {
                     "$ref": "#/definitions/services.ExportMeta"
                 },
+                "playlist": {
+                    "$ref": "#/definitions/services.ExportPlaylist"
+                },
                 "url": {
                     "type": "string"
                 }
@@ -396,10 +1261,12 @@ const docTemplate = `{
         "services.ExportKindType": {
             "type": "string",
             "enum": [
-                "subtitles"
+                "subtitles",
+                "captions"
             ],
             "x-enum-varnames": [
-                "ExportKindTypeSubtitles"
+                "ExportKindTypeSubtitles",
+                "ExportKindTypeCaptions"
             ]
         },
         "services.ExportMeta": {
@@ -411,6 +1278,10 @@ const docTemplate = `{
                 "multibitrate": {
                     "type": "boolean"
                 },
+                "role": {
+                    "description": "Role is the role the urls were minted for. It is the export api role\nwhen an invalid token was ignored.",
+                    "type": "string"
+                },
                 "transcode": {
                     "type": "boolean"
                 },
@@ -419,6 +1290,20 @@ const docTemplate = `{
                 }
             }
         },
+        "services.ExportPlaylist": {
+            "type": "object",
+            "properties": {
+                "body": {
+                    "type": "string"
+                },
+                "format": {
+                    "$ref": "#/definitions/services.PlaylistFormat"
+                },
+                "mime_type": {
+                    "type": "string"
+                }
+            }
+        },
         "services.ExportPreloadType": {
             "type": "string",
             "enum": [
@@ -433,6 +1318,10 @@ const docTemplate = `{
         "services.ExportResponse": {
             "type": "object",
             "properties": {
+                "expires_at": {
+                    "description": "ExpiresAt is when the first of the exported urls expires, omitted if\nthey never do.",
+                    "type": "string"
+                },
                 "exports": {
                     "type": "object",
                     "additionalProperties": {
@@ -503,12 +1392,24 @@ const docTemplate = `{
         "services.ExportTrack": {
             "type": "object",
             "properties": {
+                "default": {
+                    "description": "Default is set on the track in the language asked for by the lang\nquery or Accept-Language header.",
+                    "type": "boolean"
+                },
+                "forced": {
+                    "description": "Forced tracks only translate foreign dialogue and signs.",
+                    "type": "boolean"
+                },
                 "kind": {
                     "$ref": "#/definitions/services.ExportKindType"
                 },
                 "label": {
                     "type": "string"
                 },
+                "sdh": {
+                    "description": "SDH tracks also describe sounds, for the deaf and hard of hearing.\nTheir kind is captions.",
+                    "type": "boolean"
+                },
                 "src": {
                     "type": "string"
                 },
@@ -517,12 +1418,56 @@ const docTemplate = `{
                 }
             }
         },
+        "services.JWK": {
+            "type": "object",
+            "properties": {
+                "alg": {
+                    "type": "string"
+                },
+                "crv": {
+                    "type": "string"
+                },
+                "e": {
+                    "type": "string"
+                },
+                "kid": {
+                    "type": "string"
+                },
+                "kty": {
+                    "type": "string"
+                },
+                "n": {
+                    "type": "string"
+                },
+                "use": {
+                    "type": "string"
+                },
+                "x": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.JWKS": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.JWK"
+                    }
+                }
+            }
+        },
         "services.ListItem": {
             "type": "object",
             "properties": {
                 "ext": {
                     "type": "string"
                 },
+                "files_count": {
+                    "description": "FilesCount is the number of files under a directory item.",
+                    "type": "integer"
+                },
                 "id": {
                     "type": "string"
                 },
@@ -556,6 +1501,10 @@ const docTemplate = `{
                 "ext": {
                     "type": "string"
                 },
+                "files_count": {
+                    "description": "FilesCount is the number of files under a directory item.",
+                    "type": "integer"
+                },
                 "id": {
                     "type": "string"
                 },
@@ -581,6 +1530,9 @@ const docTemplate = `{
                 "name": {
                     "type": "string"
                 },
+                "next_cursor": {
+                    "type": "string"
+                },
                 "path": {
                     "type": "string"
                 },
@@ -620,6 +1572,58 @@ const docTemplate = `{
                 "Unknown"
             ]
         },
+        "services.PlaylistFormat": {
+            "type": "string",
+            "enum": [
+                "m3u8",
+                "xspf"
+            ],
+            "x-enum-varnames": [
+                "PlaylistFormatM3U8",
+                "PlaylistFormatXSPF"
+            ]
+        },
+        "services.ResourceEvent": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "stage": {
+                    "$ref": "#/definitions/services.ResourceStage"
+                }
+            }
+        },
+        "services.ResourceJobResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "$ref": "#/definitions/services.ErrorResponse"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "resource": {
+                    "$ref": "#/definitions/services.ResourceResponse"
+                },
+                "status": {
+                    "$ref": "#/definitions/services.ResourceJobStatus"
+                }
+            }
+        },
+        "services.ResourceJobStatus": {
+            "type": "string",
+            "enum": [
+                "pending",
+                "resolved",
+                "failed"
+            ],
+            "x-enum-varnames": [
+                "ResourceJobStatusPending",
+                "ResourceJobStatusResolved",
+                "ResourceJobStatusFailed"
+            ]
+        },
         "services.ResourceResponse": {
             "type": "object",
             "properties": {
@@ -653,6 +1657,23 @@ const docTemplate = `{
                     "type": "integer"
                 }
             }
+        },
+        "services.ResourceStage": {
+            "type": "string",
+            "enum": [
+                "queued",
+                "fetching_metadata",
+                "pushed",
+                "ready",
+                "failed"
+            ],
+            "x-enum-varnames": [
+                "ResourceStageQueued",
+                "ResourceStageFetchingMetadata",
+                "ResourceStagePushed",
+                "ResourceStageReady",
+                "ResourceStageFailed"
+            ]
         }
     }
 }`
@@ -25,6 +25,8 @@ func makeServeCMD() cli.Command {
 func configureServe(c *cli.Command) {
 	c.Flags = cs.RegisterProbeFlags(c.Flags)
 	c.Flags = cs.RegisterPprofFlags(c.Flags)
+	c.Flags = cs.RegisterPromFlags(c.Flags)
+	c.Flags = s.RegisterTracingFlags(c.Flags)
 	c.Flags = s.RegisterWebFlags(c.Flags)
 	c.Flags = s.RegisterTorrentStoreFlags(c.Flags)
 	c.Flags = s.RegisterMagnet2TorrentFlags(c.Flags)
@@ -32,6 +34,10 @@ func configureServe(c *cli.Command) {
 	c.Flags = s.RegisterNodesStatFlags(c.Flags)
 	c.Flags = s.RegisterVideoInfoServiceFlags(c.Flags)
 	c.Flags = s.RegisterCacheMapFlags(c.Flags)
+	c.Flags = s.RegisterMediaTypesFlags(c.Flags)
+	c.Flags = s.RegisterAuthFlags(c.Flags)
+	c.Flags = s.RegisterTokenFlags(c.Flags)
+	c.Flags = s.RegisterRateLimitFlags(c.Flags)
 }
 
 func serve(c *cli.Context) error {
@@ -52,6 +58,28 @@ func serve(c *cli.Context) error {
 		defer pprof.Close()
 	}
 
+	// Setting Prom
+	prom := cs.NewProm(c)
+	if prom != nil {
+		services = append(services, prom)
+		defer prom.Close()
+	}
+
+	// Setting Tracing
+	tracing, err := s.NewTracing(c)
+	if err != nil {
+		return err
+	}
+	if tracing != nil {
+		defer tracing.Close()
+	}
+
+	// Setting MediaTypes
+	err = s.LoadMediaTypes(c)
+	if err != nil {
+		return err
+	}
+
 	// Setting TorrentStore
 	ts := s.NewTorrentStore(c)
 	defer ts.Close()
@@ -86,6 +114,9 @@ func serve(c *cli.Context) error {
 	// Setting ResourceMap
 	rm := s.NewResourceMap(ts, m2t)
 
+	// Setting ResourceJobs
+	rj := s.NewResourceJobs(rm)
+
 	// Setting List
 	li := s.NewList()
 
@@ -98,8 +129,14 @@ func serve(c *cli.Context) error {
 	// Setting Subdomains
 	sd := s.NewSubdomains(ns)
 
+	// Setting Tokens
+	tk, err := s.NewTokens(c)
+	if err != nil {
+		return err
+	}
+
 	// Setting URLBuilder
-	ub := s.NewURLBuilder(c, sd, cm)
+	ub := s.NewURLBuilder(c, sd, cm, tk)
 
 	var exporters []s.Exporter
 
@@ -135,14 +172,43 @@ func serve(c *cli.Context) error {
 		exporters = append(exporters, mpe)
 	}
 
+	// Setting PreviewExporter
+	pve := s.NewPreviewExporter(ub)
+	if pve != nil {
+		exporters = append(exporters, pve)
+	}
+
+	// Setting PlaylistExporter
+	ple := s.NewPlaylistExporter(ub)
+	if ple != nil {
+		exporters = append(exporters, ple)
+	}
+
 	// Setting Export
 	ex := s.NewExport(exporters...)
 
 	// Setting SpeedTest
-	st := s.NewSpeedTest(c, ns)
+	st := s.NewSpeedTest(c, ns, tk)
+
+	// Setting Auth
+	var au *s.Auth
+	ks, err := s.NewFileKeyStore(c)
+	if err != nil {
+		return err
+	}
+	if ks != nil {
+		defer ks.Close()
+		au = s.NewAuth(ks)
+	}
+
+	// Setting RateLimiter
+	rl, err := s.NewRateLimiter(c, s.NewMemoryRateLimitStore(), tk)
+	if err != nil {
+		return err
+	}
 
 	// Setting Web
-	web := s.NewWeb(c, rm, li, ex, st)
+	web := s.NewWeb(c, rm, rj, li, ex, st, au, tk, rl)
 	if web != nil {
 		services = append(services, web)
 		defer web.Close()
@@ -152,7 +218,7 @@ func serve(c *cli.Context) error {
 	serve := cs.NewServe(services...)
 
 	// And SERVE!
-	err := serve.Serve()
+	err = serve.Serve()
 	if err != nil {
 		log.WithError(err).Error("got server error")
 	}
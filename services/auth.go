@@ -0,0 +1,327 @@
+package services
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	authKeysFileFlag           = "auth-keys-file"
+	authKeysReloadIntervalFlag = "auth-keys-reload-interval"
+)
+
+// authKeyContextKey holds the *APIKey of an authenticated request in the gin
+// context.
+const authKeyContextKey = "api_key"
+
+// authClaimsContextKey holds the jwt.MapClaims of the token an api key with
+// a secret was authenticated with.
+const authClaimsContextKey = "api_key_claims"
+
+// defaultQuotaPeriod is the quota window of keys without quota_period.
+const defaultQuotaPeriod = 24 * time.Hour
+
+func RegisterAuthFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   authKeysFileFlag,
+			Usage:  "YAML or JSON file with the api keys allowed to call /resource, authentication is disabled if empty",
+			Value:  "",
+			EnvVar: "AUTH_KEYS_FILE",
+		},
+		cli.DurationFlag{
+			Name:   authKeysReloadIntervalFlag,
+			Usage:  "how often the api keys file is checked for changes",
+			Value:  30 * time.Second,
+			EnvVar: "AUTH_KEYS_RELOAD_INTERVAL",
+		},
+	)
+}
+
+// APIKey is an api key allowed to call the REST endpoints.
+type APIKey struct {
+	Key  string `json:"-"`
+	Name string `json:"name,omitempty"`
+	// Secret signs the key's JWTs. When set, requests must carry a token
+	// signed with it.
+	Secret string `json:"secret,omitempty"`
+	// Quota is the number of requests allowed per QuotaPeriod, 0 for no
+	// limit.
+	Quota       int    `json:"quota,omitempty"`
+	QuotaPeriod string `json:"quota_period,omitempty"`
+	Disabled    bool   `json:"disabled,omitempty"`
+	quotaPeriod time.Duration
+	// token verifies the key's JWTs, nil without Secret.
+	token *TokenKey
+}
+
+func (s *APIKey) init(key string) {
+	s.Key = key
+	if s.quotaPeriod == 0 {
+		s.quotaPeriod = defaultQuotaPeriod
+	}
+	if s.Secret != "" {
+		s.token = newSecretKey(s.Name, s.Secret)
+	}
+}
+
+// ParseAPIKeys parses a YAML or JSON object of api keys, keyed by the key
+// itself.
+func ParseAPIKeys(b []byte) (map[string]*APIKey, error) {
+	var res map[string]*APIKey
+	if err := yaml.Unmarshal(b, &res); err != nil {
+		return nil, err
+	}
+	for k, v := range res {
+		if v == nil {
+			v = &APIKey{}
+			res[k] = v
+		}
+		v.init(k)
+		if v.QuotaPeriod != "" {
+			d, err := time.ParseDuration(v.QuotaPeriod)
+			if err != nil || d <= 0 {
+				return nil, errors.Errorf("failed to parse quota_period %q of key %v", v.QuotaPeriod, v.Name)
+			}
+			v.quotaPeriod = d
+		}
+	}
+	return res, nil
+}
+
+// KeyStore looks api keys up.
+type KeyStore interface {
+	Get(key string) (*APIKey, bool)
+}
+
+// MemoryKeyStore is a KeyStore kept in memory. Replace swaps all the keys
+// at once, so revoked keys stop working on the next request.
+type MemoryKeyStore struct {
+	mux  sync.RWMutex
+	keys map[string]*APIKey
+}
+
+func NewMemoryKeyStore(keys map[string]*APIKey) *MemoryKeyStore {
+	s := &MemoryKeyStore{}
+	s.Replace(keys)
+	return s
+}
+
+func (s *MemoryKeyStore) Get(key string) (*APIKey, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	k, ok := s.keys[key]
+	return k, ok
+}
+
+func (s *MemoryKeyStore) Replace(keys map[string]*APIKey) {
+	for k, v := range keys {
+		v.init(k)
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.keys = keys
+}
+
+// FileKeyStore is a MemoryKeyStore loaded from a file and reloaded when the
+// file changes. A file that fails to parse keeps the previous keys.
+type FileKeyStore struct {
+	*MemoryKeyStore
+	path    string
+	modTime time.Time
+	closeCh chan struct{}
+	once    sync.Once
+}
+
+func NewFileKeyStore(c *cli.Context) (*FileKeyStore, error) {
+	p := c.String(authKeysFileFlag)
+	if p == "" {
+		return nil, nil
+	}
+	s := &FileKeyStore{
+		MemoryKeyStore: NewMemoryKeyStore(nil),
+		path:           p,
+		closeCh:        make(chan struct{}),
+	}
+	if _, err := s.Load(); err != nil {
+		return nil, err
+	}
+	if i := c.Duration(authKeysReloadIntervalFlag); i > 0 {
+		go s.watch(i)
+	}
+	return s, nil
+}
+
+// Load reloads the file if it was modified since the last load.
+func (s *FileKeyStore) Load() (bool, error) {
+	st, err := os.Stat(s.path)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to stat api keys file %v", s.path)
+	}
+	if st.ModTime().Equal(s.modTime) {
+		return false, nil
+	}
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to read api keys file %v", s.path)
+	}
+	keys, err := ParseAPIKeys(b)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to parse api keys file %v", s.path)
+	}
+	s.Replace(keys)
+	s.modTime = st.ModTime()
+	log.Infof("loaded %d api keys from %v", len(keys), s.path)
+	return true, nil
+}
+
+func (s *FileKeyStore) watch(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case <-t.C:
+			if _, err := s.Load(); err != nil {
+				log.WithError(err).Error("failed to reload api keys, keeping previous ones")
+			}
+		}
+	}
+}
+
+func (s *FileKeyStore) Close() {
+	s.once.Do(func() {
+		close(s.closeCh)
+	})
+}
+
+// quotaWindow counts the requests of a key in a fixed window.
+type quotaWindow struct {
+	start time.Time
+	count int
+}
+
+// Auth authenticates /resource requests by api key and JWT against a
+// KeyStore and enforces the keys' quotas.
+type Auth struct {
+	ks      KeyStore
+	mux     sync.Mutex
+	windows map[string]*quotaWindow
+	now     func() time.Time
+}
+
+func NewAuth(ks KeyStore) *Auth {
+	return &Auth{
+		ks:      ks,
+		windows: map[string]*quotaWindow{},
+		now:     time.Now,
+	}
+}
+
+// Handler is the gin middleware of authenticated routes.
+func (s *Auth) Handler(g *gin.Context) {
+	k, claims, err := s.authenticate(g)
+	if err == nil {
+		err = s.takeQuota(g, k)
+	}
+	if err != nil {
+		_ = g.Error(err)
+		g.Abort()
+		return
+	}
+	g.Set(authKeyContextKey, k)
+	if claims != nil {
+		g.Set(authClaimsContextKey, claims)
+	}
+	g.Next()
+}
+
+// authenticate returns the api key of the request and, for keys with a
+// secret, the claims of the token signed with it.
+func (s *Auth) authenticate(g ParamGetter) (*APIKey, jwt.MapClaims, error) {
+	key := g.Query("api-key")
+	if key == "" {
+		key = g.GetHeader("X-Api-Key")
+	}
+	if key == "" {
+		return nil, nil, errUnauthorized("api key required")
+	}
+	k, ok := s.ks.Get(key)
+	if !ok || k.Disabled {
+		return nil, nil, errUnauthorized("unknown api key")
+	}
+	if k.token == nil {
+		return k, nil, nil
+	}
+	token := g.Query("token")
+	if token == "" {
+		token = g.GetHeader("X-Token")
+	}
+	if token == "" {
+		return nil, nil, errUnauthorized("token required for api key %v", k.Name)
+	}
+	claims, err := k.token.Parse(token)
+	if err != nil {
+		return nil, nil, errUnauthorized("invalid token for api key %v", k.Name)
+	}
+	return k, claims, nil
+}
+
+// takeQuota counts the request against the key's quota.
+func (s *Auth) takeQuota(g *gin.Context, k *APIKey) error {
+	if k.Quota <= 0 {
+		return nil
+	}
+	now := s.now()
+	s.mux.Lock()
+	w, ok := s.windows[k.Key]
+	if !ok || now.Sub(w.start) >= k.quotaPeriod {
+		w = &quotaWindow{start: now}
+		s.windows[k.Key] = w
+	}
+	exceeded := w.count >= k.Quota
+	if !exceeded {
+		w.count++
+	}
+	reset := w.start.Add(k.quotaPeriod)
+	s.mux.Unlock()
+	if exceeded {
+		g.Header("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds()+0.5)))
+		return errQuotaExceeded("quota of %d requests per %v exceeded for api key %v", k.Quota, k.quotaPeriod, k.Name)
+	}
+	return nil
+}
+
+// authClaims returns the claims of the token g was authenticated with, nil
+// if the api key has no secret or authentication is off.
+func authClaims(g ParamGetter) jwt.MapClaims {
+	// A gin.Context looks its keys up as context values.
+	ctx, ok := g.(context.Context)
+	if !ok {
+		return nil
+	}
+	claims, _ := ctx.Value(authClaimsContextKey).(jwt.MapClaims)
+	return claims
+}
+
+// authKey returns the api key the request was authenticated with, nil if
+// authentication is off.
+func authKey(g *gin.Context) *APIKey {
+	if v, ok := g.Get(authKeyContextKey); ok {
+		if k, ok := v.(*APIKey); ok {
+			return k
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	tsp "github.com/webtor-io/torrent-store/proto"
+)
+
+func testAuthRouter(a *Auth) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	w := &Web{}
+	r := gin.New()
+	r.Use(w.errorHandler)
+	r.GET("/resource/:resource_id", a.Handler, func(g *gin.Context) {
+		g.String(http.StatusOK, authKey(g).Name)
+	})
+	return r
+}
+
+func TestAuth_Handler(t *testing.T) {
+	keys, err := ParseAPIKeys([]byte(`
+open:
+  name: open
+signed:
+  name: signed
+  secret: s3cret
+revoked:
+  disabled: true
+`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	r := testAuthRouter(NewAuth(NewMemoryKeyStore(keys)))
+	get := func(query string, header map[string]string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/resource/"+manifestHash+query, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	sign := func(secret string) string {
+		s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{}).SignedString([]byte(secret))
+		return s
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get("", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, get("?api-key=nope", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, get("?api-key=revoked", nil).Code)
+	rec := get("", map[string]string{"X-Api-Key": "open"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "open", rec.Body.String())
+
+	assert.Equal(t, http.StatusUnauthorized, get("?api-key=signed", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, get("?api-key=signed&token="+sign("wrong"), nil).Code)
+	assert.Equal(t, http.StatusOK, get("?api-key=signed&token="+sign("s3cret"), nil).Code)
+	assert.Equal(t, http.StatusOK, get("?api-key=signed", map[string]string{"X-Token": sign("s3cret")}).Code)
+	hs512, _ := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{}).SignedString([]byte("s3cret"))
+	assert.Equal(t, http.StatusUnauthorized, get("?api-key=signed&token="+hs512, nil).Code)
+}
+
+func TestAuth_quota(t *testing.T) {
+	keys, err := ParseAPIKeys([]byte(`{"k": {"name": "k", "quota": 2, "quota_period": "1m"}}`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	a := NewAuth(NewMemoryKeyStore(keys))
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	r := testAuthRouter(a)
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/resource/"+manifestHash+"?api-key=k", nil))
+		return rec
+	}
+	assert.Equal(t, http.StatusOK, get().Code)
+	assert.Equal(t, http.StatusOK, get().Code)
+	rec := get()
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), ErrorCodeQuotaExceeded)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, get().Code)
+
+	_, err = ParseAPIKeys([]byte(`{"k": {"quota": 2, "quota_period": "daily"}}`))
+	assert.NotNil(t, err)
+}
+
+func TestFileKeyStore_Load(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "keys.yaml")
+	assert.Nil(t, os.WriteFile(p, []byte("a: {name: a}\n"), 0644))
+	s := &FileKeyStore{MemoryKeyStore: NewMemoryKeyStore(nil), path: p}
+	loaded, err := s.Load()
+	assert.Nil(t, err)
+	assert.True(t, loaded)
+	_, ok := s.Get("a")
+	assert.True(t, ok)
+
+	loaded, err = s.Load()
+	assert.Nil(t, err)
+	assert.False(t, loaded)
+
+	assert.Nil(t, os.WriteFile(p, []byte("b: {name: b}\n"), 0644))
+	assert.Nil(t, os.Chtimes(p, time.Now(), time.Now().Add(time.Second)))
+	_, err = s.Load()
+	assert.Nil(t, err)
+	_, ok = s.Get("a")
+	assert.False(t, ok)
+	_, ok = s.Get("b")
+	assert.True(t, ok)
+
+	// A broken file keeps the keys loaded before.
+	assert.Nil(t, os.WriteFile(p, []byte("not: [valid"), 0644))
+	assert.Nil(t, os.Chtimes(p, time.Now(), time.Now().Add(2*time.Second)))
+	_, err = s.Load()
+	assert.NotNil(t, err)
+	_, ok = s.Get("b")
+	assert.True(t, ok)
+}
+
+// The token of an api key is verified by Auth with the key's secret, urls
+// are then minted from its claims even in strict mode.
+func TestWeb_authenticatedExport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rm := NewTestResourceMap()
+	tsclm, _ := rm.ts.Get()
+	tsclmm := tsclm.(*TorrentStoreClientMock)
+	tsclmm.On("Files", mock.Anything, mock.Anything, mock.Anything).Return(&tsp.FilesReply{
+		Name:  "Movie",
+		Files: []*tsp.FileInfo{{Path: []string{"Movie", "movie.mp4"}, Length: 10}},
+	}, nil)
+	keys, err := ParseAPIKeys([]byte(`{"partner": {"name": "partner", "secret": "partner-secret"}}`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	tb, _ := testTagBuilder(t)
+	tb.ub.tk = testTokens("export-secret", time.Hour)
+	tb.ub.tk.strict = true
+	w := &Web{rm: rm, c: NewList(), e: NewExport(NewDownloadExporter(tb.ub))}
+	r := gin.New()
+	r.Use(w.errorHandler)
+	r.GET("/resource/:resource_id/export", NewAuth(NewMemoryKeyStore(keys)).Handler, w.getExportByPath)
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"role": "paid"}).SignedString([]byte("partner-secret"))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/resource/"+manifestHash+"/export?path=/Movie/movie.mp4&types=download&api-key=partner&token="+token, nil))
+	if !assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String()) {
+		return
+	}
+	var res ExportResponse
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	u, err := url.Parse(res.ExportItems["download"].URL)
+	if assert.Nil(t, err) {
+		claims, err := tb.ub.tk.Parse(u.Query().Get("token"))
+		if assert.Nil(t, err) {
+			assert.Equal(t, "paid", claims["role"])
+		}
+	}
+	if m := res.ExportItems["download"].Meta; assert.NotNil(t, m) {
+		assert.Equal(t, "paid", m.Role)
+	}
+}
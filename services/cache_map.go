@@ -11,6 +11,10 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"github.com/webtor-io/lazymap"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -57,7 +61,7 @@ type CacheMap struct {
 }
 
 func NewCacheMap(c *cli.Context, cl *http.Client) *CacheMap {
-	return &CacheMap{
+	cm := &CacheMap{
 		LazyMap: lazymap.New[bool](&lazymap.Config{
 			Expire: 30 * time.Second,
 		}),
@@ -67,16 +71,26 @@ func NewCacheMap(c *cli.Context, cl *http.Client) *CacheMap {
 		torrentHTTPProxyPort:        c.Int(torrentHTTPProxyPortFlag),
 		probeTimeout:                c.Duration(cacheProbeTimeoutFlag),
 	}
+	lazyMapSizes.add("cache_probes", cm.LazyMap.Len)
+	return cm
 }
 
 // Get reports whether the content behind u is already fully downloaded by the
 // seeder. The answer only fills the advisory meta.cache flag in the export
 // response, so it must never fail the export itself: any probe error degrades
 // to "not cached". See the comment at the s.cl.Do error branch.
-func (s *CacheMap) Get(u *MyURL) (bool, error) {
-	return s.LazyMap.Get(u.Path, func() (bool, error) {
-		cacheCtx, cacheCancel := context.WithTimeout(context.Background(), s.probeTimeout)
+//
+// ctx only parents the probe span: the probe itself is shared by every
+// concurrent caller for the same path, so it keeps its own deadline.
+func (s *CacheMap) Get(ctx context.Context, u *MyURL) (bool, error) {
+	return s.LazyMap.Get(u.Path, func() (cached bool, err error) {
+		cacheCtx, cacheCancel := context.WithTimeout(detachedSpanContext(ctx), s.probeTimeout)
 		defer cacheCancel()
+		cacheCtx, span := tracer.Start(cacheCtx, "CacheMap.probe", trace.WithSpanKind(trace.SpanKindClient))
+		defer func() {
+			span.SetAttributes(attribute.Bool("cache.hit", cached))
+			endSpan(span, err)
+		}()
 		i, err := url.Parse(u.String())
 		if err != nil {
 			return false, err
@@ -93,6 +107,7 @@ func (s *CacheMap) Get(u *MyURL) (bool, error) {
 		if err != nil {
 			return false, err
 		}
+		otel.GetTextMapPropagator().Inject(cacheCtx, propagation.HeaderCarrier(req.Header))
 		res, err := s.cl.Do(req)
 		if err != nil {
 			// A dead upstream (edge proxy down, seeder still cold-starting
@@ -103,11 +118,18 @@ func (s *CacheMap) Get(u *MyURL) (bool, error) {
 			// map's TTL also keeps a flapping upstream from being re-probed
 			// on every request.
 			log.WithError(err).Warnf("failed to probe cache state for %v", u.Path)
+			promCacheProbes.WithLabelValues("failure").Inc()
+			span.RecordError(err)
 			return false, nil
 		}
 		defer func(Body io.ReadCloser) {
 			_ = Body.Close()
 		}(res.Body)
-		return res.StatusCode == http.StatusOK, nil
+		if res.StatusCode != http.StatusOK {
+			promCacheProbes.WithLabelValues("miss").Inc()
+			return false, nil
+		}
+		promCacheProbes.WithLabelValues("hit").Inc()
+		return true, nil
 	})
 }
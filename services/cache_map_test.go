@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -42,7 +43,7 @@ func TestCacheMapGetSwallowsProbeErrors(t *testing.T) {
 	defer stalled.Close()
 
 	cm := newTestCacheMap(stalled.Client(), 50*time.Millisecond)
-	cached, err := cm.Get(testCacheMapURL(t, stalled.URL, "/timed-out"))
+	cached, err := cm.Get(context.Background(), testCacheMapURL(t, stalled.URL, "/timed-out"))
 	assert.Nil(err)
 	assert.False(cached)
 
@@ -51,7 +52,7 @@ func TestCacheMapGetSwallowsProbeErrors(t *testing.T) {
 	dead.Close()
 
 	cm = newTestCacheMap(deadCl, time.Second)
-	cached, err = cm.Get(testCacheMapURL(t, deadURL, "/refused"))
+	cached, err = cm.Get(context.Background(), testCacheMapURL(t, deadURL, "/refused"))
 	assert.Nil(err)
 	assert.False(cached)
 }
@@ -75,12 +76,12 @@ func TestCacheMapGetReportsUpstreamStatus(t *testing.T) {
 
 	cm := newTestCacheMap(srv.Client(), 5*time.Second)
 
-	cached, err := cm.Get(testCacheMapURL(t, srv.URL, "/done"))
+	cached, err := cm.Get(context.Background(), testCacheMapURL(t, srv.URL, "/done"))
 	assert.Nil(err)
 	assert.True(cached)
 	assert.Equal("true", gotQuery.Get("done"))
 
-	cached, err = cm.Get(testCacheMapURL(t, srv.URL, "/partial"))
+	cached, err = cm.Get(context.Background(), testCacheMapURL(t, srv.URL, "/partial"))
 	assert.Nil(err)
 	assert.False(cached)
 }
@@ -1,5 +1,15 @@
 package services
 
+import (
+	"mime"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"sigs.k8s.io/yaml"
+)
+
 type MediaFormat string
 
 const (
@@ -10,31 +20,149 @@ const (
 	Unknown  MediaFormat = "unknown"
 )
 
-var formats = map[MediaFormat][]string{
-	Video:    {"avi", "mkv", "mp4", "webm", "m4v", "ts", "vob"},
-	Audio:    {"mp3", "wav", "ogg", "flac", "m4a"},
-	Image:    {"png", "gif", "jpg", "jpeg"},
-	Subtitle: {"srt", "vtt"},
+var MediaFormats = []MediaFormat{
+	Audio,
+	Video,
+	Image,
+	Subtitle,
 }
 
-var transcodeExt = []string{"avi", "mkv", "m4v", "ts", "vob", "flac", "m4a"}
+const mediaTypesFileFlag = "media-types-file"
 
-func shouldTranscode(ext string) bool {
-	for _, te := range transcodeExt {
-		if te == ext {
+func RegisterMediaTypesFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   mediaTypesFileFlag,
+			Usage:  "YAML or JSON file with media types by file extension, merged over the built-in ones",
+			Value:  "",
+			EnvVar: "MEDIA_TYPES_FILE",
+		},
+	)
+}
+
+// MediaType describes how files with some extension are listed and
+// streamed. An empty MimeType falls back to the system MIME table.
+type MediaType struct {
+	Format    MediaFormat `json:"format"`
+	MimeType  string      `json:"mime_type,omitempty"`
+	Transcode bool        `json:"transcode,omitempty"`
+}
+
+// MediaTypes maps lowercased file extensions, without the leading dot, to
+// their media types.
+type MediaTypes map[string]MediaType
+
+var defaultMediaTypes = MediaTypes{
+	"avi":  {Format: Video, MimeType: "video/x-msvideo", Transcode: true},
+	"mkv":  {Format: Video, MimeType: "video/x-matroska", Transcode: true},
+	"mp4":  {Format: Video, MimeType: "video/mp4"},
+	"webm": {Format: Video, MimeType: "video/webm"},
+	"m4v":  {Format: Video, MimeType: "video/x-m4v", Transcode: true},
+	"ts":   {Format: Video, MimeType: "video/mp2t", Transcode: true},
+	"vob":  {Format: Video, MimeType: "video/x-ms-vob", Transcode: true},
+	"mov":  {Format: Video, MimeType: "video/quicktime", Transcode: true},
+	"wmv":  {Format: Video, MimeType: "video/x-ms-wmv", Transcode: true},
+	"flv":  {Format: Video, MimeType: "video/x-flv", Transcode: true},
+	"mp3":  {Format: Audio, MimeType: "audio/mpeg"},
+	"wav":  {Format: Audio, MimeType: "audio/wav"},
+	"ogg":  {Format: Audio, MimeType: "audio/ogg"},
+	"opus": {Format: Audio, MimeType: "audio/ogg"},
+	"aac":  {Format: Audio, MimeType: "audio/aac"},
+	"flac": {Format: Audio, MimeType: "audio/flac", Transcode: true},
+	"m4a":  {Format: Audio, MimeType: "audio/mp4", Transcode: true},
+	"png":  {Format: Image, MimeType: "image/png"},
+	"gif":  {Format: Image, MimeType: "image/gif"},
+	"jpg":  {Format: Image, MimeType: "image/jpeg"},
+	"jpeg": {Format: Image, MimeType: "image/jpeg"},
+	"webp": {Format: Image, MimeType: "image/webp"},
+	"avif": {Format: Image, MimeType: "image/avif"},
+	"srt":  {Format: Subtitle, MimeType: "application/x-subrip"},
+	"vtt":  {Format: Subtitle, MimeType: "text/vtt"},
+	"ass":  {Format: Subtitle, MimeType: "text/x-ssa"},
+	"ssa":  {Format: Subtitle, MimeType: "text/x-ssa"},
+	"sub":  {Format: Subtitle, MimeType: "text/x-microdvd"},
+	"idx":  {Format: Subtitle, MimeType: "application/x-vobsub"},
+}
+
+// mediaTypes is the registry in use. It is replaced by LoadMediaTypes at
+// startup, before any resource is listed.
+var mediaTypes = defaultMediaTypes
+
+// LoadMediaTypes merges the media types file, if any, over the built-in
+// registry and makes the result the registry in use.
+func LoadMediaTypes(c *cli.Context) error {
+	p := c.String(mediaTypesFileFlag)
+	if p == "" {
+		return nil
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read media types file %v", p)
+	}
+	mt, err := ParseMediaTypes(b)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse media types file %v", p)
+	}
+	mediaTypes = mt
+	return nil
+}
+
+// ParseMediaTypes parses a YAML or JSON object of media types by extension
+// and merges it over the built-in registry. Format unknown removes an
+// extension.
+func ParseMediaTypes(b []byte) (MediaTypes, error) {
+	var in MediaTypes
+	if err := yaml.Unmarshal(b, &in); err != nil {
+		return nil, err
+	}
+	res := MediaTypes{}
+	for k, v := range defaultMediaTypes {
+		res[k] = v
+	}
+	for k, v := range in {
+		ext := strings.ToLower(strings.TrimLeft(k, "."))
+		if ext == "" {
+			return nil, errors.Errorf("empty extension")
+		}
+		if v.Format == Unknown {
+			delete(res, ext)
+			continue
+		}
+		if !isMediaFormat(v.Format) {
+			return nil, errors.Errorf("unknown format %q of extension %v", v.Format, ext)
+		}
+		res[ext] = v
+	}
+	return res, nil
+}
+
+func isMediaFormat(f MediaFormat) bool {
+	for _, mf := range MediaFormats {
+		if mf == f {
 			return true
 		}
 	}
 	return false
 }
 
+func getMediaType(ext string) MediaType {
+	if mt, ok := mediaTypes[ext]; ok {
+		return mt
+	}
+	return MediaType{Format: Unknown}
+}
+
+func shouldTranscode(ext string) bool {
+	return getMediaType(ext).Transcode
+}
+
 func getMediaFormatByExt(ext string) MediaFormat {
-	for f, e := range formats {
-		for _, ee := range e {
-			if ee == ext {
-				return f
-			}
-		}
+	return getMediaType(ext).Format
+}
+
+func getMimeTypeByExt(ext string) string {
+	if mt := getMediaType(ext).MimeType; mt != "" {
+		return mt
 	}
-	return Unknown
+	return mime.TypeByExtension("." + ext)
 }
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildListFile_mediaTypes(t *testing.T) {
+	for name, want := range map[string]MediaFormat{
+		"clip.MOV":   Video,
+		"clip.wmv":   Video,
+		"song.opus":  Audio,
+		"song.aac":   Audio,
+		"cover.webp": Image,
+		"cover.avif": Image,
+		"movie.ass":  Subtitle,
+		"notes.txt":  "",
+	} {
+		i := buildListFile(&File{Path: []string{name}}, 0)
+		assert.Equal(t, want, i.MediaFormat, name)
+	}
+	i := buildListFile(&File{Path: []string{"movie.mkv"}}, 0)
+	assert.Equal(t, "video/x-matroska", i.MimeType)
+	assert.True(t, shouldTranscode("flv"))
+	assert.False(t, shouldTranscode("mp4"))
+}
+
+func TestParseMediaTypes(t *testing.T) {
+	mt, err := ParseMediaTypes([]byte(`
+.RMVB:
+  format: video
+  mime_type: application/vnd.rn-realmedia-vbr
+  transcode: true
+mp4:
+  format: video
+  transcode: true
+ts:
+  format: unknown
+`))
+	if assert.Nil(t, err) {
+		assert.Equal(t, MediaType{Format: Video, MimeType: "application/vnd.rn-realmedia-vbr", Transcode: true}, mt["rmvb"])
+		assert.True(t, mt["mp4"].Transcode)
+		assert.NotContains(t, mt, "ts")
+		assert.Equal(t, defaultMediaTypes["srt"], mt["srt"])
+	}
+
+	mt, err = ParseMediaTypes([]byte(`{"mka": {"format": "audio", "transcode": true}}`))
+	if assert.Nil(t, err) {
+		assert.Equal(t, Audio, mt["mka"].Format)
+	}
+
+	_, err = ParseMediaTypes([]byte(`{"mka": {"format": "sound"}}`))
+	assert.NotNil(t, err)
+	_, err = ParseMediaTypes([]byte(`not: [valid`))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// dirIndexMaxSorted bounds the number of sorted listings kept per index, so
+// a client walking every directory with every sort can't grow it unbounded.
+const dirIndexMaxSorted = 256
+
+// DirIndex is the directory tree of a resource, built once per Resource
+// (see Resource.Index). Every directory and file item is prebuilt with its
+// ID, so listing, tree output and content lookups no longer rescan r.Files
+// or hash paths on every request.
+type DirIndex struct {
+	// items holds every directory and file in list order: files in the
+	// torrent's natural order, each directory right before its first file.
+	items []ListItem
+	// parents holds the directory of every item, by position in items.
+	parents []*dirNode
+	// files maps r.Files positions to positions in items.
+	files []int
+	// dirs and byPath are keyed by NFC-normalized path, so paths typed in
+	// NFD (as macOS does) match too.
+	dirs   map[string]*dirNode
+	byID   map[string]int
+	byPath map[string]int
+	root   *dirNode
+
+	mux         sync.Mutex
+	sortedCache map[dirIndexSortKey][]int
+}
+
+type dirIndexSortKey struct {
+	node   *dirNode
+	output ListOutputType
+	sort   ListSortType
+	order  ListOrder
+}
+
+type dirNode struct {
+	// item is the position of the directory in DirIndex.items, -1 for the
+	// root.
+	item   int
+	parent *dirNode
+	size   int64
+	count  int
+	// children are the direct children, in first-seen order.
+	children []int
+	// list is every descendant, in list order.
+	list []int
+}
+
+func newDirIndex(r *Resource) *DirIndex {
+	s := &DirIndex{
+		files:  make([]int, len(r.Files)),
+		dirs:   map[string]*dirNode{},
+		byID:   map[string]int{},
+		byPath: map[string]int{},
+		root:   &dirNode{item: -1},
+
+		sortedCache: map[dirIndexSortKey][]int{},
+	}
+	s.dirs[""] = s.root
+	ancestors := []*dirNode{}
+	for i, f := range r.Files {
+		node := s.root
+		ancestors = append(ancestors[:0], s.root)
+		for d := 1; d < len(f.Path); d++ {
+			key := norm.NFC.String(strings.Join(f.Path[:d], "/"))
+			n, ok := s.dirs[key]
+			if !ok {
+				fps := "/" + strings.Join(f.Path[:d], "/")
+				n = &dirNode{item: len(s.items), parent: node}
+				s.add(ListItem{
+					ID:      fmt.Sprintf("%x", sha1.Sum([]byte(fps))),
+					Name:    f.Path[d-1],
+					PathStr: fps,
+					Path:    f.Path[:d],
+					Type:    ListTypeDirectory,
+				}, node, ancestors)
+				s.dirs[key] = n
+			}
+			node = n
+			ancestors = append(ancestors, n)
+		}
+		s.files[i] = len(s.items)
+		s.add(buildListFile(f, i), node, ancestors)
+		for _, a := range ancestors {
+			a.size += f.Size
+			a.count++
+		}
+	}
+	for _, n := range s.dirs {
+		if n.item >= 0 {
+			s.items[n.item].Size = n.size
+			s.items[n.item].FilesCount = n.count
+		}
+	}
+	return s
+}
+
+func (s *DirIndex) add(i ListItem, parent *dirNode, ancestors []*dirNode) {
+	pos := len(s.items)
+	s.items = append(s.items, i)
+	s.parents = append(s.parents, parent)
+	if _, ok := s.byID[i.ID]; !ok {
+		s.byID[i.ID] = pos
+	}
+	key := norm.NFC.String(strings.Trim(i.PathStr, "/"))
+	if _, ok := s.byPath[key]; !ok {
+		s.byPath[key] = pos
+	}
+	parent.children = append(parent.children, pos)
+	for _, a := range ancestors {
+		a.list = append(a.list, pos)
+	}
+}
+
+// positions returns the item positions listed under the node: every
+// descendant in list output, direct children in tree output.
+func (s *dirNode) positions(output ListOutputType) []int {
+	if output == ListOutputTypeList {
+		return s.list
+	}
+	return s.children
+}
+
+// sorted returns the positions listed under node in the requested order.
+// The result is shared and must not be modified.
+func (s *DirIndex) sorted(node *dirNode, output ListOutputType, st ListSortType, order ListOrder) []int {
+	less := listItemLess(st, order)
+	if less == nil {
+		return node.positions(output)
+	}
+	key := dirIndexSortKey{node: node, output: output, sort: st, order: order}
+	s.mux.Lock()
+	res, ok := s.sortedCache[key]
+	s.mux.Unlock()
+	if ok {
+		return res
+	}
+	res = append([]int(nil), node.positions(output)...)
+	sort.SliceStable(res, func(i, j int) bool {
+		return less(&s.items[res[i]], &s.items[res[j]])
+	})
+	s.mux.Lock()
+	if len(s.sortedCache) < dirIndexMaxSorted {
+		s.sortedCache[key] = res
+	}
+	s.mux.Unlock()
+	return res
+}
+
+func (s *DirIndex) dir(path []string) *dirNode {
+	return s.dirs[norm.NFC.String(strings.Join(path, "/"))]
+}
+
+// Root returns the item of the resource root.
+func (s *DirIndex) Root() ListItem {
+	i := buildRootItem([]string{}, s.root.size)
+	i.FilesCount = s.root.count
+	return i
+}
+
+// Item returns the directory or file item by ID, the root included.
+func (s *DirIndex) Item(id string) (ListItem, bool) {
+	if pos, ok := s.byID[id]; ok {
+		return s.items[pos], true
+	}
+	if root := s.Root(); root.ID == id {
+		return root, true
+	}
+	return ListItem{}, false
+}
+
+// Path returns the directory or file item by its path inside the torrent,
+// the root included. Leading and trailing slashes are optional and the
+// path is matched regardless of Unicode normalization form.
+func (s *DirIndex) Path(p string) (ListItem, bool) {
+	key := norm.NFC.String(strings.Trim(p, "/"))
+	if key == "" {
+		return s.Root(), true
+	}
+	if pos, ok := s.byPath[key]; ok {
+		return s.items[pos], true
+	}
+	return ListItem{}, false
+}
+
+// Files returns the file items under the directory at path, in the
+// torrent's natural order.
+func (s *DirIndex) Files(path []string) []ListItem {
+	node := s.dir(path)
+	if node == nil {
+		return nil
+	}
+	res := make([]ListItem, 0, node.count)
+	for _, pos := range node.list {
+		if s.items[pos].Type == ListTypeFile {
+			res = append(res, s.items[pos])
+		}
+	}
+	return res
+}
+
+// File returns the item of the file at idx in the torrent's natural order.
+func (s *DirIndex) File(idx int) (ListItem, bool) {
+	if idx < 0 || idx >= len(s.files) {
+		return ListItem{}, false
+	}
+	return s.items[s.files[idx]], true
+}
+
+// Len returns the number of files.
+func (s *DirIndex) Len() int {
+	return len(s.files)
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirIndex(t *testing.T) {
+	r := &Resource{
+		Files: []*File{
+			{Path: []string{"a", "b", "file1.txt"}, Size: 10},
+			{Path: []string{"a", "b", "file2.txt"}, Size: 20},
+			{Path: []string{"a", "c", "file3.txt"}, Size: 30},
+			{Path: []string{"root.txt"}, Size: 5},
+		},
+	}
+	idx := r.Index()
+	assert.Same(t, idx, r.Index())
+	assert.Equal(t, 4, idx.Len())
+
+	root := idx.Root()
+	assert.Equal(t, int64(65), root.Size)
+	assert.Equal(t, 4, root.FilesCount)
+
+	a, ok := idx.Item(fmt.Sprintf("%x", sha1.Sum([]byte("/a"))))
+	if assert.True(t, ok) {
+		assert.Equal(t, ListTypeDirectory, a.Type)
+		assert.Equal(t, int64(60), a.Size)
+		assert.Equal(t, 3, a.FilesCount)
+	}
+	f, ok := idx.File(2)
+	if assert.True(t, ok) {
+		assert.Equal(t, "/a/c/file3.txt", f.PathStr)
+		assert.Equal(t, 2, f.Index)
+	}
+	_, ok = idx.File(4)
+	assert.False(t, ok)
+	_, ok = idx.Item(root.ID)
+	assert.True(t, ok)
+	_, ok = idx.Item("unknown")
+	assert.False(t, ok)
+
+	resp := NewList().buildTree(r, &ListGetArgs{Path: []string{"a"}})
+	assert.Equal(t, []string{"b", "c"}, listNames(resp.Items))
+	assert.Equal(t, int64(30), resp.Items[0].Size)
+	assert.Equal(t, int64(60), resp.Size)
+
+	resp = NewList().buildList(r, &ListGetArgs{Path: []string{"a"}})
+	assert.Equal(t, []string{"b", "file1.txt", "file2.txt", "c", "file3.txt"}, listNames(resp.Items))
+	// Nested directory sizes cover their own subtree only.
+	assert.Equal(t, int64(30), resp.Items[0].Size)
+
+	resp = NewList().buildTree(r, &ListGetArgs{Path: []string{"missing"}})
+	assert.Empty(t, resp.Items)
+	assert.Equal(t, int64(0), resp.Size)
+}
+
+// testLargeResource is a synthetic season-pack-like manifest: 100k files
+// spread over 100 shows of 10 seasons.
+func testLargeResource() *Resource {
+	r := &Resource{}
+	for i := 0; i < 100000; i++ {
+		r.Files = append(r.Files, &File{
+			Path: []string{
+				fmt.Sprintf("Show %d", i/1000),
+				fmt.Sprintf("Season %d", i/100%10),
+				fmt.Sprintf("Episode %d.mkv", i%100),
+			},
+			Size: int64(i),
+		})
+	}
+	return r
+}
+
+func BenchmarkDirIndex_build(b *testing.B) {
+	r := testLargeResource()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newDirIndex(r)
+	}
+}
+
+func BenchmarkList_buildList(b *testing.B) {
+	l := NewList()
+	r := testLargeResource()
+	r.Index()
+	args := &ListGetArgs{Path: []string{}, Sort: ListSortTypeName, Limit: 100}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.buildList(r, args)
+	}
+}
+
+func BenchmarkList_buildListFiltered(b *testing.B) {
+	l := NewList()
+	r := testLargeResource()
+	r.Index()
+	args := &ListGetArgs{Path: []string{}, Query: "episode 1*", Limit: 100}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.buildList(r, args)
+	}
+}
+
+func BenchmarkList_buildTree(b *testing.B) {
+	l := NewList()
+	r := testLargeResource()
+	r.Index()
+	args := &ListGetArgs{Path: []string{"Show 42"}, Sort: ListSortTypeName, Limit: 100}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.buildTree(r, args)
+	}
+}
+
+func BenchmarkDirIndex_Item(b *testing.B) {
+	r := testLargeResource()
+	idx := r.Index()
+	id := fmt.Sprintf("%x", sha1.Sum([]byte("/Show 42/Season 3/Episode 7.mkv")))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.Item(id)
+	}
+}
+
+func TestDirIndex_Path(t *testing.T) {
+	nfc := "Am\u00e9lie"
+	nfd := "Ame\u0301lie"
+	r := &Resource{
+		Files: []*File{
+			{Path: []string{nfc, nfc + ".mkv"}, Size: 10},
+			{Path: []string{nfc, "Subs", "en.srt"}, Size: 1},
+		},
+	}
+	idx := r.Index()
+
+	f, ok := idx.Path("/" + nfd + "/" + nfd + ".mkv")
+	if assert.True(t, ok) {
+		assert.Equal(t, "/"+nfc+"/"+nfc+".mkv", f.PathStr)
+		assert.Equal(t, ListTypeFile, f.Type)
+	}
+	d, ok := idx.Path(nfd + "/Subs/")
+	if assert.True(t, ok) {
+		assert.Equal(t, ListTypeDirectory, d.Type)
+		assert.Equal(t, 1, d.FilesCount)
+	}
+	root, ok := idx.Path("/")
+	if assert.True(t, ok) {
+		assert.Equal(t, 2, root.FilesCount)
+	}
+	_, ok = idx.Path("/" + nfc + "/missing.mkv")
+	assert.False(t, ok)
+
+	resp := NewList().buildTree(r, &ListGetArgs{Path: []string{nfd}})
+	assert.Equal(t, []string{nfc + ".mkv", "Subs"}, listNames(resp.Items))
+}
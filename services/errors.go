@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+	gcodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorCode is the stable, machine-readable kind of an API error. It is
+// returned as ErrorResponse.Code so clients can decide whether to retry
+// without looking at the message.
+type ErrorCode string
+
+const (
+	ErrorCodeBadRequest          ErrorCode = "bad_request"
+	ErrorCodeUnauthorized        ErrorCode = "unauthorized"
+	ErrorCodeInvalidToken        ErrorCode = "invalid_token"
+	ErrorCodeForbidden           ErrorCode = "forbidden"
+	ErrorCodeNotFound            ErrorCode = "not_found"
+	ErrorCodeQuotaExceeded       ErrorCode = "quota_exceeded"
+	ErrorCodeRateLimited         ErrorCode = "rate_limited"
+	ErrorCodeUpstreamTimeout     ErrorCode = "upstream_timeout"
+	ErrorCodeUpstreamUnavailable ErrorCode = "upstream_unavailable"
+	ErrorCodeInternal            ErrorCode = "internal"
+)
+
+var errorCodeStatuses = map[ErrorCode]int{
+	ErrorCodeBadRequest:          http.StatusBadRequest,
+	ErrorCodeUnauthorized:        http.StatusUnauthorized,
+	ErrorCodeInvalidToken:        http.StatusUnauthorized,
+	ErrorCodeForbidden:           http.StatusForbidden,
+	ErrorCodeNotFound:            http.StatusNotFound,
+	ErrorCodeQuotaExceeded:       http.StatusTooManyRequests,
+	ErrorCodeRateLimited:         http.StatusTooManyRequests,
+	ErrorCodeUpstreamTimeout:     http.StatusGatewayTimeout,
+	ErrorCodeUpstreamUnavailable: http.StatusServiceUnavailable,
+	ErrorCodeInternal:            http.StatusInternalServerError,
+}
+
+// APIError tags an error with its ErrorCode. The message of the wrapped
+// error is kept as is, the code never depends on it.
+type APIError struct {
+	Code ErrorCode
+	err  error
+}
+
+func (s *APIError) Error() string {
+	return s.err.Error()
+}
+
+func (s *APIError) Unwrap() error {
+	return s.err
+}
+
+func errBadRequest(format string, args ...any) error {
+	return &APIError{Code: ErrorCodeBadRequest, err: errors.Errorf(format, args...)}
+}
+
+func errNotFound(format string, args ...any) error {
+	return &APIError{Code: ErrorCodeNotFound, err: errors.Errorf(format, args...)}
+}
+
+func errUnauthorized(format string, args ...any) error {
+	return &APIError{Code: ErrorCodeUnauthorized, err: errors.Errorf(format, args...)}
+}
+
+func errQuotaExceeded(format string, args ...any) error {
+	return &APIError{Code: ErrorCodeQuotaExceeded, err: errors.Errorf(format, args...)}
+}
+
+func errRateLimited(format string, args ...any) error {
+	return &APIError{Code: ErrorCodeRateLimited, err: errors.Errorf(format, args...)}
+}
+
+func errForbidden(err error) error {
+	return &APIError{Code: ErrorCodeForbidden, err: errors.Wrap(err, "forbidden")}
+}
+
+func wrapBadRequest(err error, msg string) error {
+	return &APIError{Code: ErrorCodeBadRequest, err: errors.Wrap(err, msg)}
+}
+
+func wrapInvalidToken(err error, msg string) error {
+	return &APIError{Code: ErrorCodeInvalidToken, err: errors.Wrap(err, msg)}
+}
+
+func wrapUpstreamTimeout(err error, msg string) error {
+	return &APIError{Code: ErrorCodeUpstreamTimeout, err: errors.Wrap(err, msg)}
+}
+
+func wrapUpstreamUnavailable(err error, msg string) error {
+	return &APIError{Code: ErrorCodeUpstreamUnavailable, err: errors.Wrap(err, msg)}
+}
+
+// upstreamError classifies an error returned by a torrent-store or
+// magnet2torrent call. Deadlines and unreachable backends become upstream
+// errors so clients know a retry may help; anything else stays internal.
+func upstreamError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var ae *APIError
+	if errors.As(err, &ae) {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &APIError{Code: ErrorCodeUpstreamTimeout, err: err}
+	}
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case gcodes.DeadlineExceeded:
+			return &APIError{Code: ErrorCodeUpstreamTimeout, err: err}
+		case gcodes.Unavailable:
+			return &APIError{Code: ErrorCodeUpstreamUnavailable, err: err}
+		}
+	}
+	return err
+}
+
+// ErrorCodeOf returns the ErrorCode of err, ErrorCodeInternal for untyped
+// errors.
+func ErrorCodeOf(err error) ErrorCode {
+	var ae *APIError
+	if errors.As(err, &ae) {
+		return ae.Code
+	}
+	return ErrorCodeInternal
+}
+
+// errorStatus maps an error to the HTTP status of its response. Shared by
+// errorHandler and the per-item statuses of POST /resource/batch.
+func errorStatus(err error) int {
+	if s, ok := errorCodeStatuses[ErrorCodeOf(err)]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+func newErrorResponse(err error) *ErrorResponse {
+	return &ErrorResponse{
+		Error: err.Error(),
+		Code:  ErrorCodeOf(err),
+	}
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The status must follow the error type only: a torrent or file whose name
+// happens to read "not found" or "timeout" must not flip an internal error
+// into a client one.
+func TestErrorStatus_IgnoresMessage(t *testing.T) {
+	assert := assert.New(t)
+	err := errors.New(`failed to build url for "not found - timeout (forbidden).mkv"`)
+	assert.Equal(http.StatusInternalServerError, errorStatus(err))
+	assert.Equal(ErrorCodeInternal, ErrorCodeOf(err))
+}
+
+func TestErrorStatus_Typed(t *testing.T) {
+	assert := assert.New(t)
+	cases := []struct {
+		err    error
+		code   ErrorCode
+		status int
+	}{
+		{errBadRequest("failed to parse limit"), ErrorCodeBadRequest, http.StatusBadRequest},
+		{errNotFound("content with id %v not found", "abc"), ErrorCodeNotFound, http.StatusNotFound},
+		{errForbidden(errors.New("restricted")), ErrorCodeForbidden, http.StatusForbidden},
+		{errUnauthorized("unknown api key"), ErrorCodeUnauthorized, http.StatusUnauthorized},
+		{wrapInvalidToken(errors.New("token is expired"), "invalid token"), ErrorCodeInvalidToken, http.StatusUnauthorized},
+		{errQuotaExceeded("quota exceeded"), ErrorCodeQuotaExceeded, http.StatusTooManyRequests},
+		{errRateLimited("rate limit exceeded"), ErrorCodeRateLimited, http.StatusTooManyRequests},
+		{wrapUpstreamTimeout(errors.New("deadline"), "magnet timeout"), ErrorCodeUpstreamTimeout, http.StatusGatewayTimeout},
+		{upstreamError(status.Error(codes.Unavailable, "connection refused")), ErrorCodeUpstreamUnavailable, http.StatusServiceUnavailable},
+		{upstreamError(status.Error(codes.DeadlineExceeded, "deadline exceeded")), ErrorCodeUpstreamTimeout, http.StatusGatewayTimeout},
+		{upstreamError(context.DeadlineExceeded), ErrorCodeUpstreamTimeout, http.StatusGatewayTimeout},
+		{upstreamError(status.Error(codes.Internal, "boom")), ErrorCodeInternal, http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		// Wrapping with context on the way up must keep the type.
+		err := errors.Wrap(c.err, "failed to get resource")
+		assert.Equal(c.code, ErrorCodeOf(err), c.err.Error())
+		assert.Equal(c.status, errorStatus(err), c.err.Error())
+		assert.Equal(c.code, newErrorResponse(err).Code)
+	}
+}
@@ -1,9 +1,12 @@
 package services
 
 import (
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/pkg/errors"
 	"github.com/urfave/cli"
 )
 
@@ -16,6 +19,10 @@ const (
 	exportApiSecretFlag         = "export-api-secret"
 	exportApiRoleFlag           = "export-api-role"
 	exportPathPrefixFlag        = "export-path-prefix"
+	exportPreviewFlag           = "export-preview"
+	exportTokenTTLFlag          = "export-token-ttl"
+	exportTokenBindIPFlag       = "export-token-bind-ip"
+	exportTokenStrictFlag       = "export-token-strict"
 )
 
 const (
@@ -89,6 +96,27 @@ func RegisterExportFlags(f []cli.Flag) []cli.Flag {
 			EnvVar: "EXPORT_PATH_PREFIX",
 			Value:  "/",
 		},
+		cli.BoolFlag{
+			Name:   exportPreviewFlag,
+			Usage:  "export video thumbnails and image previews",
+			EnvVar: "EXPORT_PREVIEW",
+		},
+		cli.DurationFlag{
+			Name:   exportTokenTTLFlag,
+			Usage:  "lifetime of the tokens of exported urls, 0 for no expiry",
+			Value:  24 * time.Hour,
+			EnvVar: "EXPORT_TOKEN_TTL",
+		},
+		cli.BoolFlag{
+			Name:   exportTokenBindIPFlag,
+			Usage:  "bind the tokens of exported urls to the client ip",
+			EnvVar: "EXPORT_TOKEN_BIND_IP",
+		},
+		cli.BoolFlag{
+			Name:   exportTokenStrictFlag,
+			Usage:  "reject invalid tokens with 401 instead of falling back to the export api role",
+			EnvVar: "EXPORT_TOKEN_STRICT",
+		},
 	)
 }
 
@@ -100,6 +128,8 @@ const (
 	ExportTypeTorrentStat ExportType = "torrent_client_stat"
 	ExportTypeSubtitles   ExportType = "subtitles"
 	ExportTypeMediaProbe  ExportType = "media_probe"
+	ExportTypePlaylist    ExportType = "playlist"
+	ExportTypePreview     ExportType = "preview"
 )
 
 var ExportTypes = []ExportType{
@@ -108,6 +138,19 @@ var ExportTypes = []ExportType{
 	ExportTypeTorrentStat,
 	ExportTypeSubtitles,
 	ExportTypeMediaProbe,
+	ExportTypePlaylist,
+	ExportTypePreview,
+}
+
+// defaultExportTypes are exported when no types are requested. Playlists
+// build a stream url for every file of a directory, so they are opt-in.
+var defaultExportTypes = []ExportType{
+	ExportTypeDownload,
+	ExportTypeStream,
+	ExportTypeTorrentStat,
+	ExportTypeSubtitles,
+	ExportTypeMediaProbe,
+	ExportTypePreview,
 }
 
 type ExportGetArgs struct {
@@ -132,11 +175,11 @@ func ExportGetArgsFromParams(g ParamGetter) (*ExportGetArgs, error) {
 				}
 			}
 			if !found {
-				return nil, errors.Errorf("failed to parse export type \"%v\"", kk)
+				return nil, errBadRequest("failed to parse export type \"%v\"", kk)
 			}
 		}
 	} else {
-		types = ExportTypes
+		types = defaultExportTypes
 	}
 	return &ExportGetArgs{
 		Types: types,
@@ -156,6 +199,7 @@ func NewExport(e ...Exporter) *Export {
 
 func (s *Export) Get(r *Resource, i *ListItem, args *ExportGetArgs, g ParamGetter) (*ExportResponse, error) {
 	items := map[string]ExportItem{}
+	var exp time.Time
 	for _, t := range args.Types {
 		for _, e := range s.exporters {
 			if e.Type() == t {
@@ -165,14 +209,27 @@ func (s *Export) Get(r *Resource, i *ListItem, args *ExportGetArgs, g ParamGette
 				}
 				if ex != nil {
 					items[ex.Type] = *ex
+					exp = earliest(exp, ex.expiresAt)
 				}
 			}
 		}
 	}
-	return &ExportResponse{
+	res := &ExportResponse{
 		Source:      *i,
 		ExportItems: items,
-	}, nil
+	}
+	if !exp.IsZero() {
+		res.ExpiresAt = &exp
+	}
+	return res, nil
+}
+
+// earliest returns the earlier of two expiries, zero meaning never.
+func earliest(a time.Time, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
 }
 
 type BaseExporter struct {
@@ -209,6 +266,14 @@ type SubtitlesExporter struct {
 	BaseExporter
 }
 
+type PlaylistExporter struct {
+	BaseExporter
+}
+
+type PreviewExporter struct {
+	BaseExporter
+}
+
 func NewDownloadExporter(ub *URLBuilder) *DownloadExporter {
 	return &DownloadExporter{
 		BaseExporter: BaseExporter{
@@ -225,8 +290,9 @@ func (s *DownloadExporter) Export(r *Resource, i *ListItem, g ParamGetter) (*Exp
 	}
 
 	return &ExportItem{
-		Type: string(s.Type()),
-		URL:  url.String(),
+		Type:      string(s.Type()),
+		URL:       url.String(),
+		expiresAt: url.expiresAt,
 		ExportMetaItem: ExportMetaItem{
 			Meta: url.BuildExportMeta(),
 		},
@@ -277,6 +343,7 @@ func (s *StreamExporter) Export(r *Resource, i *ListItem, g ParamGetter) (*Expor
 		Type:             string(s.Type()),
 		URL:              url.String(),
 		ExportStreamItem: *ei,
+		expiresAt:        url.expiresAt,
 		ExportMetaItem: ExportMetaItem{
 			Meta: url.BuildExportMeta(),
 		},
@@ -302,8 +369,9 @@ func (s *TorrentStatExporter) Export(r *Resource, i *ListItem, g ParamGetter) (*
 	}
 
 	return &ExportItem{
-		Type: string(s.Type()),
-		URL:  url.String(),
+		Type:      string(s.Type()),
+		URL:       url.String(),
+		expiresAt: url.expiresAt,
 	}, nil
 }
 
@@ -331,8 +399,9 @@ func (s *SubtitlesExporter) Export(r *Resource, i *ListItem, g ParamGetter) (*Ex
 		return nil, nil
 	}
 	return &ExportItem{
-		Type: string(s.Type()),
-		URL:  url.String(),
+		Type:      string(s.Type()),
+		URL:       url.String(),
+		expiresAt: url.expiresAt,
 	}, nil
 }
 
@@ -353,8 +422,132 @@ func (s *MediaProbeExporter) Export(r *Resource, i *ListItem, g ParamGetter) (*E
 	if url == nil {
 		return nil, nil
 	}
+	return &ExportItem{
+		Type:      string(s.Type()),
+		URL:       url.String(),
+		expiresAt: url.expiresAt,
+	}, nil
+}
+
+func NewPlaylistExporter(ub *URLBuilder) *PlaylistExporter {
+	return &PlaylistExporter{
+		BaseExporter: BaseExporter{
+			ub:         ub,
+			exportType: ExportTypePlaylist,
+		},
+	}
+}
+
+// Export renders a playlist of every video and audio file under a directory,
+// each entry pointing to the file's stream url.
+func (s *PlaylistExporter) Export(r *Resource, i *ListItem, g ParamGetter) (*ExportItem, error) {
+	if i.Type != ListTypeDirectory {
+		return nil, nil
+	}
+	f, err := PlaylistFormatFromParams(g)
+	if err != nil {
+		return nil, err
+	}
+	var files []ListItem
+	for _, v := range r.Index().Files(i.Path) {
+		if v.MediaFormat == Video || v.MediaFormat == Audio {
+			files = append(files, v)
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	if len(files) > maxPlaylistEntries {
+		return nil, errBadRequest("failed to build playlist, directory should have less than %d playable files", maxPlaylistEntries)
+	}
+	sort.SliceStable(files, func(a, b int) bool {
+		return naturalCompare(files[a].PathStr, files[b].PathStr) < 0
+	})
+	entries, exp, err := s.buildEntries(r, files, g)
+	if err != nil {
+		return nil, err
+	}
+	body, err := renderPlaylist(f, s.title(r, i), entries)
+	if err != nil {
+		return nil, err
+	}
 	return &ExportItem{
 		Type: string(s.Type()),
-		URL:  url.String(),
+		ExportPlaylistItem: ExportPlaylistItem{
+			Playlist: &ExportPlaylist{
+				Format:   f,
+				MimeType: f.MimeType(),
+				Body:     body,
+			},
+		},
+		expiresAt: exp,
+	}, nil
+}
+
+func (s *PlaylistExporter) title(r *Resource, i *ListItem) string {
+	if len(i.Path) > 0 {
+		return i.Path[len(i.Path)-1]
+	}
+	return r.Name
+}
+
+// buildEntries builds the stream urls of files concurrently, keeping their
+// order, and returns when the first of them expires.
+func (s *PlaylistExporter) buildEntries(r *Resource, files []ListItem, g ParamGetter) ([]PlaylistEntry, time.Time, error) {
+	g, limiter := sharedParams(g)
+	entries := make([]PlaylistEntry, len(files))
+	exps := make([]time.Time, len(files))
+	errs := make([]error, len(files))
+	var wg sync.WaitGroup
+	for n := range files {
+		limiter.Go(&wg, func() {
+			u, err := s.ub.Build(r, &files[n], g, ExportTypeStream)
+			if err != nil {
+				errs[n] = err
+				return
+			}
+			name := files[n].Name
+			entries[n].Title = strings.TrimSuffix(name, filepath.Ext(name))
+			if u != nil {
+				entries[n].URL = u.String()
+				exps[n] = u.expiresAt
+			}
+		})
+	}
+	wg.Wait()
+	var exp time.Time
+	for n, err := range errs {
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		exp = earliest(exp, exps[n])
+	}
+	return entries, exp, nil
+}
+
+func NewPreviewExporter(ub *URLBuilder) *PreviewExporter {
+	return &PreviewExporter{
+		BaseExporter: BaseExporter{
+			ub:         ub,
+			exportType: ExportTypePreview,
+		},
+	}
+}
+
+func (s *PreviewExporter) Export(r *Resource, i *ListItem, g ParamGetter) (*ExportItem, error) {
+	if i.MediaFormat != Video && i.MediaFormat != Image {
+		return nil, nil
+	}
+	url, err := s.BuildURL(r, i, g)
+	if err != nil {
+		return nil, err
+	}
+	if url == nil {
+		return nil, nil
+	}
+	return &ExportItem{
+		Type:      string(s.Type()),
+		URL:       url.String(),
+		expiresAt: url.expiresAt,
 	}, nil
 }
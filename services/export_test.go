@@ -0,0 +1,70 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewExporter_Export(t *testing.T) {
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer probe.Close()
+	ub := &URLBuilder{
+		cm:         newTestCacheMap(probe.Client(), time.Second),
+		domain:     probe.URL,
+		pathPrefix: "/",
+		tk:         testTokens("", 0),
+	}
+	e := NewPreviewExporter(ub)
+	r := &Resource{
+		ID:   manifestHash,
+		Name: "Movie",
+		Files: []*File{
+			{Path: []string{"Movie", "Movie.mkv"}, Size: 1},
+			{Path: []string{"Movie", "still.png"}, Size: 1},
+			{Path: []string{"Movie", "Movie.srt"}, Size: 1},
+		},
+	}
+	video, _ := r.Index().Path("/Movie/Movie.mkv")
+	image, _ := r.Index().Path("/Movie/still.png")
+	sub, _ := r.Index().Path("/Movie/Movie.srt")
+
+	// Previews are off unless the preview service is deployed.
+	ei, err := e.Export(r, &video, testParams{})
+	assert.Nil(t, err)
+	assert.Nil(t, ei)
+
+	ub.usePreview = true
+	ei, err = e.Export(r, &video, testParams{"preview-at": {"90"}, "preview-width": {"320"}})
+	if assert.Nil(t, err) && assert.NotNil(t, ei) {
+		assert.Equal(t, probe.URL+"/"+manifestHash+"/Movie/Movie.mkv~pv/Movie.jpg?t=90&w=320", ei.URL)
+	}
+	ei, err = e.Export(r, &image, testParams{})
+	if assert.Nil(t, err) && assert.NotNil(t, ei) {
+		assert.Equal(t, probe.URL+"/"+manifestHash+"/Movie/still.png~pv/still.png", ei.URL)
+	}
+	ei, err = e.Export(r, &sub, testParams{})
+	assert.Nil(t, err)
+	assert.Nil(t, ei)
+
+	_, err = e.Export(r, &video, testParams{"preview-at": {"-1"}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+	_, err = e.Export(r, &image, testParams{"preview-width": {"wide"}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+
+	// A video without poster image gets its thumbnail as poster.
+	et, err := NewTagBuilder(ub, NewList()).Build(r, &video, testParams{})
+	if assert.Nil(t, err) {
+		assert.Equal(t, probe.URL+"/"+manifestHash+"/Movie/still.png", et.Poster)
+	}
+	r = &Resource{ID: manifestHash, Name: "Movie", Files: r.Files[:1]}
+	et, err = NewTagBuilder(ub, NewList()).Build(r, &video, testParams{})
+	if assert.Nil(t, err) {
+		assert.Equal(t, probe.URL+"/"+manifestHash+"/Movie/Movie.mkv~pv/Movie.jpg?t=30", et.Poster)
+	}
+}
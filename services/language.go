@@ -0,0 +1,286 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// iso6392B maps the ISO 639-2 bibliographic codes, still common in release
+// names, to their terminology counterparts.
+var iso6392B = map[string]string{
+	"alb": "sq",
+	"arm": "hy",
+	"baq": "eu",
+	"bur": "my",
+	"chi": "zh",
+	"cze": "cs",
+	"dut": "nl",
+	"fre": "fr",
+	"geo": "ka",
+	"ger": "de",
+	"gre": "el",
+	"ice": "is",
+	"mac": "mk",
+	"mao": "mi",
+	"may": "ms",
+	"per": "fa",
+	"rum": "ro",
+	"slo": "sk",
+	"tib": "bo",
+	"wel": "cy",
+}
+
+// releaseLanguages are the languages subtitles of releases come in. Only
+// their ISO 639-1 and 639-2/B/T codes are matched in a name, as other short
+// codes are mostly words or release tokens: in, to, PAL. Those that are
+// words as well are in wordCodes.
+var releaseLanguages = []string{
+	"af", "am", "ar", "az", "be", "bg", "bn", "bs", "ca", "cs", "cy", "da",
+	"de", "el", "en", "eo", "es", "et", "eu", "fa", "fi", "fil", "fr", "ga",
+	"gl", "gu", "he", "hi", "hr", "hu", "hy", "id", "is", "it", "ja", "ka",
+	"kk", "km", "kn", "ko", "ku", "lo", "lt", "lv", "mk", "ml", "mn", "mr",
+	"ms", "my", "nb", "ne", "nl", "nn", "no", "pa", "pl", "ps", "pt", "ro",
+	"ru", "si", "sk", "sl", "sq", "sr", "sv", "sw", "ta", "te", "th", "tl",
+	"tr", "uk", "ur", "uz", "vi", "zh",
+}
+
+// wordCodes are the codes of releaseLanguages that are words too, like it,
+// no or per. They only count as the final token of a name, in lower case,
+// so Movie.it.srt is Italian but Movie.It.Is.srt or Per.Aspera.srt are not.
+var wordCodes = map[string]bool{
+	"am": true, "be": true, "he": true, "hi": true, "id": true, "is": true,
+	"it": true, "my": true, "no": true,
+	"arm": true, "ben": true, "bur": true, "cat": true, "chi": true,
+	"dan": true, "fin": true, "geo": true, "hun": true, "ice": true,
+	"lit": true, "mac": true, "mal": true, "mar": true, "may": true,
+	"pan": true, "per": true, "sin": true, "tam": true, "wel": true,
+}
+
+// wordCode reports whether tok is a word code out of place, the last token
+// of a name or not.
+func wordCode(tok string, last bool) bool {
+	return wordCodes[strings.ToLower(tok)] && (!last || tok != strings.ToLower(tok))
+}
+
+var (
+	releaseCodesOnce sync.Once
+	// releaseCodes maps the codes of releaseLanguages to their bases.
+	releaseCodes map[string]language.Base
+)
+
+func loadReleaseCodes() {
+	releaseCodes = map[string]language.Base{}
+	for _, c := range releaseLanguages {
+		b := language.MustParseBase(c)
+		releaseCodes[c] = b
+		releaseCodes[b.ISO3()] = b
+	}
+	for bc, c := range iso6392B {
+		if b, ok := releaseCodes[c]; ok {
+			releaseCodes[bc] = b
+		}
+	}
+}
+
+// regionalLanguages are the regional variants subtitles are commonly told
+// apart by, named like Brazilian Portuguese or Latin American Spanish.
+var regionalLanguages = []string{
+	"pt-BR", "pt-PT",
+	"es-ES", "es-419", "es-MX",
+	"fr-FR", "fr-CA",
+	"en-US", "en-GB",
+	"zh-Hans", "zh-Hant",
+}
+
+var (
+	languageNamesOnce sync.Once
+	// languageNames maps lowercased English and native language names,
+	// regional variants included, to their tags.
+	languageNames map[string]language.Tag
+)
+
+func loadLanguageNames() {
+	languageNames = map[string]language.Tag{}
+	add := func(t language.Tag) {
+		for _, n := range []string{display.English.Tags().Name(t), display.Self.Name(t)} {
+			n = strings.ToLower(n)
+			if n == "" || strings.Contains(n, "unknown") {
+				continue
+			}
+			if _, ok := languageNames[n]; !ok {
+				languageNames[n] = t
+			}
+		}
+	}
+	// Base languages go first, so a plain name never maps to a region.
+	for _, b := range display.Supported.BaseLanguages() {
+		add(language.Make(b.String()))
+	}
+	for _, t := range display.Supported.Tags() {
+		add(t)
+	}
+	for _, t := range regionalLanguages {
+		add(language.MustParse(t))
+	}
+}
+
+// parseLanguage resolves a single name token to a language: an ISO 639-1
+// or 639-2 code of releaseLanguages, a BCP 47 tag like pt-BR of one of them,
+// or an English or native language name.
+func parseLanguage(s string) (language.Tag, bool) {
+	s = strings.ToLower(s)
+	releaseCodesOnce.Do(loadReleaseCodes)
+	if b, ok := releaseCodes[s]; ok {
+		return language.Make(b.String()), true
+	}
+	if strings.ContainsRune(s, '-') {
+		t, err := language.Parse(s)
+		if err == nil {
+			if b, c := t.Base(); c == language.Exact && releaseCodes[b.String()] == b {
+				return t, true
+			}
+		}
+	}
+	languageNamesOnce.Do(loadLanguageNames)
+	t, ok := languageNames[s]
+	return t, ok
+}
+
+// parseLanguageCode resolves any ISO 639 code with an English name, like
+// the 639-3 code yue, besides what parseLanguage does.
+func parseLanguageCode(s string) (language.Tag, bool) {
+	s = strings.ToLower(s)
+	if t, ok := parseLanguage(s); ok {
+		return t, true
+	}
+	if len(s) != 2 && len(s) != 3 {
+		return language.Tag{}, false
+	}
+	b, err := language.ParseBase(s)
+	if err != nil || languageName(b) == "" {
+		return language.Tag{}, false
+	}
+	return language.Make(b.String()), true
+}
+
+// languageName returns the English name of b, empty if it has none.
+func languageName(b language.Base) string {
+	n := display.English.Languages().Name(b)
+	if n == "Unknown language" {
+		return ""
+	}
+	return n
+}
+
+// languageLabel returns the English name of t, like Brazilian Portuguese.
+func languageLabel(t language.Tag) string {
+	return display.English.Tags().Name(t)
+}
+
+// nameTokens splits a file or folder name into words. Dashes split words
+// too, unless they join a language tag like pt-BR.
+func nameTokens(name string) []string {
+	var res []string
+	for _, f := range strings.FieldsFunc(name, func(r rune) bool {
+		return r == '.' || r == '_' || r == ',' || r == '[' || r == ']' ||
+			r == '(' || r == ')' || r == '{' || r == '}' || unicode.IsSpace(r)
+	}) {
+		if strings.ContainsRune(f, '-') {
+			if _, ok := parseLanguage(f); ok {
+				res = append(res, f)
+				continue
+			}
+			for _, p := range strings.Split(f, "-") {
+				if p != "" {
+					res = append(res, p)
+				}
+			}
+			continue
+		}
+		res = append(res, f)
+	}
+	return res
+}
+
+// languageToken is a language found in a name, with the token(s) it was
+// found by.
+type languageToken struct {
+	token string
+	tag   language.Tag
+}
+
+// nameLanguages returns the languages found in the tokens, in order. Two
+// word names like Brazilian Portuguese are matched before single words.
+// Too many other codes are words to trust them anywhere, a 639-3 code only
+// counts as the final token, and if nothing else does. So do wordCodes.
+func nameLanguages(tokens []string) []languageToken {
+	var res []languageToken
+	for i := 0; i < len(tokens); i++ {
+		if i+1 < len(tokens) {
+			pair := tokens[i] + " " + tokens[i+1]
+			if t, ok := parseLanguage(pair); ok {
+				res = append(res, languageToken{token: strings.ToLower(pair), tag: t})
+				i++
+				continue
+			}
+		}
+		if wordCode(tokens[i], i == len(tokens)-1) {
+			continue
+		}
+		if t, ok := parseLanguage(tokens[i]); ok {
+			res = append(res, languageToken{token: strings.ToLower(tokens[i]), tag: t})
+		}
+	}
+	if n := len(tokens); len(res) == 0 && n > 0 && len(tokens[n-1]) == 3 && !wordCode(tokens[n-1], true) {
+		if t, ok := parseLanguageCode(tokens[n-1]); ok {
+			res = append(res, languageToken{token: strings.ToLower(tokens[n-1]), tag: t})
+		}
+	}
+	return res
+}
+
+// languageFolder returns the languages of a folder named after them, like
+// Russian, 2_English or English (SDH). Folders with other words in their
+// name, like Songs In English, are not language folders.
+func languageFolder(name string) []languageToken {
+	tokens := nameTokens(name)
+	langs := nameLanguages(tokens)
+	words := map[string]bool{}
+	for _, l := range langs {
+		for _, w := range strings.Fields(l.token) {
+			words[w] = true
+		}
+	}
+	for _, tok := range tokens {
+		tok = strings.ToLower(tok)
+		switch {
+		case words[tok], tok == "forced", tok == "sdh", tok == "cc":
+		case strings.Trim(tok, "0123456789") == "":
+		default:
+			return nil
+		}
+	}
+	return langs
+}
+
+// preferredLanguages returns the languages asked for by the lang query,
+// comma-separated, or else by the Accept-Language header.
+func preferredLanguages(g ParamGetter) []language.Tag {
+	var res []language.Tag
+	if q := g.Query("lang"); q != "" {
+		for _, v := range strings.Split(q, ",") {
+			if t, ok := parseLanguageCode(strings.TrimSpace(v)); ok {
+				res = append(res, t)
+			}
+		}
+		return res
+	}
+	if h := g.GetHeader("Accept-Language"); h != "" {
+		res, _, _ = language.ParseAcceptLanguage(h)
+	}
+	return res
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNameLanguages(t *testing.T) {
+	for name, want := range map[string]string{
+		"Movie_rus":            "ru",
+		"Movie [Spanish]":      "es",
+		"Movie.English":        "en",
+		"Movie.pt-BR":          "pt-BR",
+		"Movie.ger":            "de",
+		"Movie.fre":            "fr",
+		"Movie.deu":            "de",
+		"Movie (Deutsch)":      "de",
+		"Movie.Español":        "es",
+		"Русский":              "ru",
+		"Brazilian Portuguese": "pt-BR",
+		"Movie.2020.1080p":     "",
+		"Movie.en.PAL":         "en",
+		"Movie.en.Songs.In.To": "en",
+		"Movie.In":             "",
+		"Movie.yue":            "yue",
+		"Movie.yue.en":         "en",
+		"Movie.it":             "it",
+		"Movie.en.hi":          "hi",
+		"Movie.It.Is":          "",
+		"Movie.It":             "",
+		"Per.Aspera":           "",
+		"Per.Aspera.ice":       "is",
+		"Movie.no.English":     "en",
+		"Cat.People":           "",
+	} {
+		langs := nameLanguages(nameTokens(name))
+		got := ""
+		if len(langs) > 0 {
+			got = langs[len(langs)-1].tag.String()
+		}
+		assert.Equal(t, want, got, name)
+	}
+}
+
+func TestLanguageFolder(t *testing.T) {
+	for name, want := range map[string]bool{
+		"Russian":          true,
+		"2_English":        true,
+		"English (SDH)":    true,
+		"pt-BR":            true,
+		"Songs In English": false,
+		"Extras":           false,
+	} {
+		assert.Equal(t, want, len(languageFolder(name)) > 0, name)
+	}
+}
+
+func TestPreferredLanguages(t *testing.T) {
+	tags := preferredLanguages(testParams{"lang": {"French, de"}})
+	if assert.Len(t, tags, 2) {
+		assert.Equal(t, "fr", tags[0].String())
+		assert.Equal(t, "de", tags[1].String())
+	}
+	assert.Empty(t, preferredLanguages(testParams{}))
+}
@@ -2,14 +2,14 @@ package services
 
 import (
 	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"mime"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/pkg/errors"
 )
 
 type ListOutputType string
@@ -21,12 +21,32 @@ const (
 
 type List struct{}
 
+const (
+	listMaxLimit = 1000
+	// listCursorMaxLimit applies when paging by cursor: resuming after the
+	// last item costs nothing, unlike deep offsets.
+	listCursorMaxLimit = 10000
+)
+
 type ListSortType string
 
 const (
-	ListSortTypeNone ListSortType = ""
-	ListSortTypeName ListSortType = "name"
-	ListSortTypeSize ListSortType = "size"
+	ListSortTypeNone        ListSortType = ""
+	ListSortTypeName        ListSortType = "name"
+	ListSortTypeSize        ListSortType = "size"
+	ListSortTypeIndex       ListSortType = "index"
+	ListSortTypeExt         ListSortType = "ext"
+	ListSortTypeMediaFormat ListSortType = "media_format"
+)
+
+// ListOrder is the direction of the sort key. ListOrderDefault is ascending
+// for every key but size, which lists the largest files first.
+type ListOrder string
+
+const (
+	ListOrderDefault ListOrder = ""
+	ListOrderAsc     ListOrder = "asc"
+	ListOrderDesc    ListOrder = "desc"
 )
 
 type ListGetArgs struct {
@@ -35,6 +55,65 @@ type ListGetArgs struct {
 	Output ListOutputType
 	Path   []string
 	Sort   ListSortType
+	Order  ListOrder
+	// Query filters files by name, lowercased. It is a glob if it contains
+	// any of *?[, a substring otherwise.
+	Query        string
+	MediaFormats []MediaFormat
+	Exts         []string
+	// After is the ID of the last item of the previous page, set from the
+	// cursor. The page starts right after it.
+	After string
+}
+
+// listCursor is the state behind the opaque cursor param. Everything that
+// shapes the item sequence is kept, so a cursor is rejected when replayed
+// with different arguments.
+type listCursor struct {
+	Output       ListOutputType `json:"o"`
+	Sort         ListSortType   `json:"s"`
+	Order        ListOrder      `json:"r,omitempty"`
+	Path         []string       `json:"p"`
+	Query        string         `json:"q,omitempty"`
+	MediaFormats []MediaFormat  `json:"m,omitempty"`
+	Exts         []string       `json:"e,omitempty"`
+	Offset       int            `json:"n"`
+	After        string         `json:"a"`
+}
+
+func (s *ListGetArgs) cursor(offset int, after string) string {
+	b, _ := json.Marshal(&listCursor{
+		Output:       s.Output,
+		Sort:         s.Sort,
+		Order:        s.Order,
+		Path:         s.Path,
+		Query:        s.Query,
+		MediaFormats: s.MediaFormats,
+		Exts:         s.Exts,
+		Offset:       offset,
+		After:        after,
+	})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (s *ListGetArgs) applyCursor(cursor string) error {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return errBadRequest("failed to parse cursor")
+	}
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Offset < 0 {
+		return errBadRequest("failed to parse cursor")
+	}
+	if c.Output != s.Output || c.Sort != s.Sort || c.Order != s.Order || c.Query != s.Query ||
+		!slices.Equal(c.Path, s.Path) ||
+		!slices.Equal(c.MediaFormats, s.MediaFormats) ||
+		!slices.Equal(c.Exts, s.Exts) {
+		return errBadRequest("cursor does not match output, sort, order, path or filters")
+	}
+	s.Offset = c.Offset
+	s.After = c.After
+	return nil
 }
 
 type ParamGetter interface {
@@ -62,20 +141,28 @@ func ListGetArgsFromParams(g ParamGetter) (*ListGetArgs, error) {
 	case "":
 		res.Output = ListOutputTypeList
 	default:
-		return nil, errors.Errorf("failed to parse output, should be tree or list")
+		return nil, errBadRequest("failed to parse output, should be tree or list")
+	}
+	cursor := g.Query("cursor")
+	if cursor != "" && g.Query("offset") != "" {
+		return nil, errBadRequest("failed to parse offset, can't be used with cursor")
 	}
 	if g.Query("limit") == "" {
-		res.Limit = 1000
+		res.Limit = listMaxLimit
 	} else {
 		limit, err := strconv.Atoi(g.Query("limit"))
 		if err != nil {
-			return nil, errors.Errorf("failed to parse limit, should be integer")
+			return nil, errBadRequest("failed to parse limit, should be integer")
 		}
-		if limit > 1000 {
-			return nil, errors.Errorf("failed to parse limit, should be less than 1000")
+		maxLimit := listMaxLimit
+		if cursor != "" {
+			maxLimit = listCursorMaxLimit
+		}
+		if limit > maxLimit {
+			return nil, errBadRequest("failed to parse limit, should be less than %v", maxLimit)
 		}
 		if limit < 1 {
-			return nil, errors.Errorf("failed to parse limit, should be more than 1")
+			return nil, errBadRequest("failed to parse limit, should be more than 1")
 		}
 		res.Limit = limit
 	}
@@ -84,10 +171,10 @@ func ListGetArgsFromParams(g ParamGetter) (*ListGetArgs, error) {
 	} else {
 		offset, err := strconv.Atoi(g.Query("offset"))
 		if err != nil {
-			return nil, errors.Errorf("failed to parse offset, should be integer")
+			return nil, errBadRequest("failed to parse offset, should be integer")
 		}
 		if offset < 0 {
-			return nil, errors.Errorf("failed to parse offset, should be positive")
+			return nil, errBadRequest("failed to parse offset, should be positive")
 		}
 		res.Offset = offset
 	}
@@ -103,49 +190,93 @@ func ListGetArgsFromParams(g ParamGetter) (*ListGetArgs, error) {
 		res.Sort = ListSortTypeName
 	case "size":
 		res.Sort = ListSortTypeSize
+	case "index":
+		res.Sort = ListSortTypeIndex
+	case "ext":
+		res.Sort = ListSortTypeExt
+	case "media_format":
+		res.Sort = ListSortTypeMediaFormat
 	case "":
 		res.Sort = ListSortTypeNone
 	default:
-		return nil, errors.Errorf("failed to parse sort, should be name or size")
+		return nil, errBadRequest("failed to parse sort, should be name, size, index, ext or media_format")
+	}
+	switch g.Query("order") {
+	case "asc":
+		res.Order = ListOrderAsc
+	case "desc":
+		res.Order = ListOrderDesc
+	case "":
+		res.Order = ListOrderDefault
+	default:
+		return nil, errBadRequest("failed to parse order, should be asc or desc")
+	}
+	res.Query = strings.ToLower(strings.TrimSpace(g.Query("q")))
+	if _, err := filepath.Match(res.Query, ""); err != nil {
+		return nil, errBadRequest("failed to parse q, malformed glob pattern")
+	}
+	for _, v := range listQueryValues(g, "media_format") {
+		mf := MediaFormat(v)
+		if !isMediaFormat(mf) {
+			return nil, errBadRequest("failed to parse media_format, unknown format %v", v)
+		}
+		res.MediaFormats = append(res.MediaFormats, mf)
+	}
+	for _, v := range listQueryValues(g, "ext") {
+		res.Exts = append(res.Exts, strings.TrimLeft(v, "."))
+	}
+	if cursor != "" {
+		if err := res.applyCursor(cursor); err != nil {
+			return nil, err
+		}
 	}
 	return res, nil
 }
 
-func NewList() *List {
-	return &List{}
+// listQueryValues collects comma-separated values of a repeatable query
+// param, so both ext=mkv,mp4 and ext=mkv&ext=mp4 work.
+func listQueryValues(g ParamGetter, name string) []string {
+	var res []string
+	for _, q := range g.QueryArray(name) {
+		for _, v := range strings.Split(q, ",") {
+			v = strings.ToLower(strings.TrimSpace(v))
+			if v != "" {
+				res = append(res, v)
+			}
+		}
+	}
+	return res
 }
 
-func pathBeginsWith(source []string, start []string) bool {
-	if len(start) == 0 {
+func (s *ListGetArgs) filtered() bool {
+	return s.Query != "" || len(s.MediaFormats) > 0 || len(s.Exts) > 0
+}
+
+// match reports whether f passes the q, media_format and ext filters.
+func (s *ListGetArgs) match(f *File) bool {
+	name := strings.ToLower(f.Path[len(f.Path)-1])
+	ext := fileExt(name)
+	if len(s.Exts) > 0 && !slices.Contains(s.Exts, ext) {
+		return false
+	}
+	if len(s.MediaFormats) > 0 && !slices.Contains(s.MediaFormats, getMediaFormatByExt(ext)) {
+		return false
+	}
+	if s.Query == "" {
 		return true
 	}
-	check := true
-	for i, p := range source {
-		if len(start) <= i {
-			break
-		}
-		if start[i] != p {
-			check = false
-		}
+	if strings.ContainsAny(s.Query, "*?[") {
+		ok, _ := filepath.Match(s.Query, name)
+		return ok
 	}
-	return check
+	return strings.Contains(name, s.Query)
 }
 
-func (s *List) buildDirSize(r *Resource, prefix []string) int64 {
-	var size int64
-	for _, f := range r.Files {
-		if !pathBeginsWith(f.Path, prefix) {
-			if size > 0 {
-				break
-			}
-			continue
-		}
-		size += f.Size
-	}
-	return size
+func NewList() *List {
+	return &List{}
 }
 
-func (s *List) buildRootItem(path []string, size int64) ListItem {
+func buildRootItem(path []string, size int64) ListItem {
 	fps := "/" + strings.Join(path, "/")
 	return ListItem{
 		ID:      fmt.Sprintf("%x", sha1.Sum([]byte(fps))),
@@ -156,105 +287,294 @@ func (s *List) buildRootItem(path []string, size int64) ListItem {
 	}
 }
 
-func (s *List) buildList(r *Resource, args *ListGetArgs) ListResponse {
-	var items []ListItem
-	var size int64
-	// seen tracks already-emitted directory prefixes by their joined path.
-	// Was a [][]string scanned linearly with testEq for every path component
-	// of every file — O(files * depth * dirs), quadratic in directory count
-	// and a memory/CPU spike on torrents with thousands of nested dirs. A
-	// string-keyed set makes the dedup O(1) per check with identical output
-	// (dirs are still emitted in first-seen order).
-	seen := map[string]struct{}{}
-	for i, f := range r.Files {
-		if !pathBeginsWith(f.Path, args.Path) {
+// listSelection is the part of a directory selected by the listing filters.
+type listSelection struct {
+	root ListItem
+	// dirs holds the selected size and file count of the directories
+	// containing matches, by position in DirIndex.items.
+	dirs  map[int]*dirNode
+	files map[int]struct{}
+}
+
+func (s *listSelection) has(pos int) bool {
+	if _, ok := s.files[pos]; ok {
+		return true
+	}
+	_, ok := s.dirs[pos]
+	return ok
+}
+
+// item returns the item at pos with its directory stats narrowed down to
+// the matching files.
+func (s *listSelection) item(idx *DirIndex, pos int) ListItem {
+	i := idx.items[pos]
+	if d, ok := s.dirs[pos]; ok {
+		i.Size = d.size
+		i.FilesCount = d.count
+	}
+	return i
+}
+
+// selectFiles applies the q, media_format and ext filters to the files under
+// node. Directories are kept only if they contain matches, with size and
+// file count of the matches alone.
+func (s *List) selectFiles(r *Resource, idx *DirIndex, node *dirNode, args *ListGetArgs) *listSelection {
+	sel := &listSelection{
+		root:  buildRootItem(args.Path, 0),
+		dirs:  map[int]*dirNode{},
+		files: map[int]struct{}{},
+	}
+	for _, pos := range node.list {
+		it := &idx.items[pos]
+		if it.Type != ListTypeFile || !args.match(r.Files[it.Index]) {
 			continue
 		}
-		if len(f.Path) > len(args.Path) {
-			var p []string
-			for _, v := range f.Path[len(args.Path) : len(f.Path)-1] {
-				p = append(p, v)
-				key := strings.Join(p, "/")
-				if _, ok := seen[key]; !ok {
-					seen[key] = struct{}{}
-					var fp []string
-					fp = append(fp, args.Path...)
-					fp = append(fp, p...)
-					fps := "/" + strings.Join(fp, "/")
-					items = append(items, ListItem{
-						ID:      fmt.Sprintf("%x", sha1.Sum([]byte(fps))),
-						Name:    p[len(p)-1],
-						Size:    s.buildDirSize(r, p),
-						PathStr: fps,
-						Path:    fp,
-						Type:    ListTypeDirectory,
-					})
-				}
+		sel.files[pos] = struct{}{}
+		sel.root.Size += it.Size
+		sel.root.FilesCount++
+		for d := idx.parents[pos]; d != node; d = d.parent {
+			ds, ok := sel.dirs[d.item]
+			if !ok {
+				ds = &dirNode{}
+				sel.dirs[d.item] = ds
 			}
+			ds.size += it.Size
+			ds.count++
+		}
+	}
+	return sel
+}
+
+func (s *List) build(r *Resource, args *ListGetArgs, output ListOutputType) ListResponse {
+	idx := r.Index()
+	node := idx.dir(args.Path)
+	if node == nil {
+		return ListResponse{ListItem: buildRootItem(args.Path, 0)}
+	}
+	if !args.filtered() {
+		// Unfiltered listings page through the cached sorted positions and
+		// copy just the page.
+		positions := idx.sorted(node, output, args.Sort, args.Order)
+		page, next := paginate(positions, args, func(pos int) string { return idx.items[pos].ID })
+		items := make([]ListItem, 0, len(page))
+		for _, pos := range page {
+			items = append(items, idx.items[pos])
+		}
+		root := buildRootItem(args.Path, node.size)
+		root.FilesCount = node.count
+		return ListResponse{
+			ListItem:   root,
+			Items:      items,
+			Count:      len(positions),
+			NextCursor: next,
+		}
+	}
+	sel := s.selectFiles(r, idx, node, args)
+	var items []ListItem
+	for _, pos := range node.positions(output) {
+		if sel.has(pos) {
+			items = append(items, sel.item(idx, pos))
 		}
-		size += f.Size
-		items = append(items, s.buildFile(f, i))
 	}
 
 	// Sort items with folders first, then by selected criteria
-	s.sortItems(items, args.Sort)
+	s.sortItemsWithOrder(items, args.Sort, args.Order)
 
 	count := len(items)
 
 	// Apply pagination after sorting
-	if args.Offset > 0 || (args.Limit != 0 && args.Offset+args.Limit < len(items)) {
-		var limitted []ListItem
+	items, next := paginate(items, args, listItemID)
+
+	return ListResponse{
+		ListItem:   sel.root,
+		Items:      items,
+		Count:      count,
+		NextCursor: next,
+	}
+}
+
+func (s *List) buildList(r *Resource, args *ListGetArgs) ListResponse {
+	return s.build(r, args, ListOutputTypeList)
+}
+
+// paginate cuts the page out of sorted items and returns the cursor of the
+// next one, empty on the last page. With a cursor the page normally starts
+// at its offset; the item there is checked against the cursor, and looked up
+// by ID if it moved, so a page is never skipped or repeated.
+func paginate[T any](items []T, args *ListGetArgs, id func(T) string) ([]T, string) {
+	start := args.Offset
+	if args.After != "" && (start == 0 || start > len(items) || id(items[start-1]) != args.After) {
+		start = len(items)
 		for n, i := range items {
-			if n >= args.Offset+args.Limit {
+			if id(i) == args.After {
+				start = n + 1
 				break
 			}
-			if n >= args.Offset {
-				limitted = append(limitted, i)
-			}
 		}
-		items = limitted
 	}
-
-	return ListResponse{
-		ListItem: s.buildRootItem(args.Path, size),
-		Items:    items,
-		Count:    count,
+	if start > len(items) {
+		start = len(items)
 	}
+	end := len(items)
+	if args.Limit != 0 && start+args.Limit < end {
+		end = start + args.Limit
+	}
+	var next string
+	if end < len(items) {
+		next = args.cursor(end, id(items[end-1]))
+	}
+	return items[start:end], next
+}
+
+func listItemID(i ListItem) string {
+	return i.ID
 }
 
 func (s *List) sortItems(items []ListItem, sortType ListSortType) {
-	if sortType == ListSortTypeNone {
+	s.sortItemsWithOrder(items, sortType, ListOrderDefault)
+}
+
+func (s *List) sortItemsWithOrder(items []ListItem, sortType ListSortType, order ListOrder) {
+	less := listItemLess(sortType, order)
+	if less == nil {
 		return
 	}
 	sort.SliceStable(items, func(i, j int) bool {
+		return less(&items[i], &items[j])
+	})
+}
+
+// listItemLess orders folders first, then by the sort key in the given
+// order. Ties fall back to the natural name order, and with sort=index
+// folders keep their first-seen order. Returns nil for ListSortTypeNone.
+func listItemLess(sortType ListSortType, order ListOrder) func(a, b *ListItem) bool {
+	if sortType == ListSortTypeNone {
+		return nil
+	}
+	desc := order == ListOrderDesc || (order == ListOrderDefault && sortType == ListSortTypeSize)
+	return func(a, b *ListItem) bool {
 		// Folders always come first
-		if items[i].Type == ListTypeDirectory && items[j].Type == ListTypeFile {
+		if a.Type == ListTypeDirectory && b.Type == ListTypeFile {
 			return true
 		}
-		if items[i].Type == ListTypeFile && items[j].Type == ListTypeDirectory {
+		if a.Type == ListTypeFile && b.Type == ListTypeDirectory {
 			return false
 		}
 
 		// Both are same type, apply sorting criteria
+		var c int
 		switch sortType {
 		case ListSortTypeSize:
-			if items[i].Size != items[j].Size {
-				return items[i].Size > items[j].Size
+			c = cmpInt64(a.Size, b.Size)
+		case ListSortTypeIndex:
+			c = a.Index - b.Index
+		case ListSortTypeExt:
+			c = strings.Compare(a.Ext, b.Ext)
+		case ListSortTypeMediaFormat:
+			c = strings.Compare(string(a.MediaFormat), string(b.MediaFormat))
+		}
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+		if sortType == ListSortTypeIndex {
+			return false
+		}
+		c = naturalCompare(a.Name, b.Name)
+		if sortType == ListSortTypeName && desc {
+			c = -c
+		}
+		return c < 0
+	}
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// naturalCompare compares strings case-insensitively, with digit runs
+// compared by their numeric value, so "Episode 2" goes before "Episode 10".
+// Strings equal this way are compared as is to keep the order total.
+func naturalCompare(a, b string) int {
+	la, lb := a, b
+	// Most names are ASCII: fold them byte by byte below instead of
+	// allocating lowercase copies for every comparison.
+	ascii := isASCII(a) && isASCII(b)
+	if !ascii {
+		la, lb = strings.ToLower(a), strings.ToLower(b)
+	}
+	i, j := 0, 0
+	for i < len(la) && j < len(lb) {
+		if isDigit(la[i]) && isDigit(lb[j]) {
+			si, sj := i, j
+			for i < len(la) && isDigit(la[i]) {
+				i++
+			}
+			for j < len(lb) && isDigit(lb[j]) {
+				j++
+			}
+			na := strings.TrimLeft(la[si:i], "0")
+			nb := strings.TrimLeft(lb[sj:j], "0")
+			if len(na) != len(nb) {
+				return len(na) - len(nb)
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
 			}
-			// If sizes are equal, fall back to alphabetical
-			return strings.ToLower(items[i].Name) < strings.ToLower(items[j].Name)
-		case ListSortTypeName:
-			fallthrough
-		default:
-			return strings.ToLower(items[i].Name) < strings.ToLower(items[j].Name)
+			continue
 		}
-	})
+		ca, cb := la[i], lb[j]
+		if ascii {
+			ca, cb = lowerASCII(ca), lowerASCII(cb)
+		}
+		if ca != cb {
+			return int(ca) - int(cb)
+		}
+		i++
+		j++
+	}
+	if c := (len(la) - i) - (len(lb) - j); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func lowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
 }
 
 func (s *List) buildFile(f *File, idx int) ListItem {
+	return buildListFile(f, idx)
+}
+
+func buildListFile(f *File, idx int) ListItem {
 	fps := "/" + strings.Join(f.Path, "/")
 	name := f.Path[len(f.Path)-1]
-	ext := strings.ToLower(strings.TrimLeft(filepath.Ext(name), "."))
+	ext := fileExt(name)
 	i := ListItem{
 		ID:      fmt.Sprintf("%x", sha1.Sum([]byte(fps))),
 		Name:    name,
@@ -268,72 +588,21 @@ func (s *List) buildFile(f *File, idx int) ListItem {
 	mf := getMediaFormatByExt(ext)
 	if mf != Unknown {
 		i.MediaFormat = mf
-		i.MimeType = mime.TypeByExtension("." + ext)
+		i.MimeType = getMimeTypeByExt(ext)
 	}
 	return i
 }
 
-func (s *List) buildTree(r *Resource, args *ListGetArgs) ListResponse {
-	items := []ListItem{}
-	var size int64
-	var dir *ListItem
-	for i, f := range r.Files {
-		if !pathBeginsWith(f.Path, args.Path) {
-			continue
-		}
-		size += f.Size
-		if len(args.Path)+1 == len(f.Path) {
-			if dir != nil {
-				items = append(items, *dir)
-				dir = nil
-			}
-			items = append(items, s.buildFile(f, i))
-		} else {
-			fps := "/" + strings.Join(f.Path[0:len(args.Path)+1], "/")
-			if dir != nil && dir.PathStr != fps {
-				items = append(items, *dir)
-				dir = nil
-			}
-			if dir == nil {
-				dir = &ListItem{
-					ID:      fmt.Sprintf("%x", sha1.Sum([]byte(fps))),
-					Name:    f.Path[len(args.Path)],
-					PathStr: fps,
-					Path:    f.Path[0 : len(args.Path)+1],
-					Type:    ListTypeDirectory,
-				}
-			}
-			dir.Size += f.Size
-		}
-	}
-	if dir != nil {
-		items = append(items, *dir)
-		dir = nil
-	}
-
-	// Sort items with folders first, then by selected criteria
-	s.sortItems(items, args.Sort)
-
-	count := len(items)
-
-	if args.Offset > 0 || (args.Limit != 0 && args.Offset+args.Limit < len(items)) {
-		var limitted []ListItem
-		for n, i := range items {
-			if n >= args.Offset+args.Limit {
-				break
-			}
-			if n >= args.Offset {
-				limitted = append(limitted, i)
-			}
-		}
-		items = limitted
-	}
+func fileExt(name string) string {
+	return strings.ToLower(strings.TrimLeft(filepath.Ext(name), "."))
+}
 
-	return ListResponse{
-		ListItem: s.buildRootItem(args.Path, size),
-		Items:    items,
-		Count:    count,
+func (s *List) buildTree(r *Resource, args *ListGetArgs) ListResponse {
+	res := s.build(r, args, ListOutputTypeTree)
+	if res.Items == nil {
+		res.Items = []ListItem{}
 	}
+	return res
 }
 
 func (s *List) Get(r *Resource, args *ListGetArgs) (ListResponse, error) {
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFlatResource(n int) *Resource {
+	r := &Resource{}
+	for i := 0; i < n; i++ {
+		r.Files = append(r.Files, &File{Path: []string{"dir", fmt.Sprintf("file%03d.mkv", i)}, Size: int64(i)})
+	}
+	return r
+}
+
+func TestList_cursorWalksAllItems(t *testing.T) {
+	l := NewList()
+	r := testFlatResource(25)
+	var names []string
+	cursor := ""
+	for pages := 0; pages < 10; pages++ {
+		p := testParams{"limit": {"10"}, "sort": {"name"}, "path": {"dir"}, "output": {"tree"}}
+		if cursor != "" {
+			p["cursor"] = []string{cursor}
+		}
+		args, err := ListGetArgsFromParams(p)
+		if !assert.Nil(t, err) {
+			return
+		}
+		resp, err := l.Get(r, args)
+		assert.Nil(t, err)
+		assert.Equal(t, 25, resp.Count)
+		names = append(names, listNames(resp.Items)...)
+		cursor = resp.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	assert.Len(t, names, 25)
+	assert.Equal(t, "file000.mkv", names[0])
+	assert.Equal(t, "file024.mkv", names[24])
+}
+
+func TestList_cursorRejectsOtherArgs(t *testing.T) {
+	l := NewList()
+	args, err := ListGetArgsFromParams(testParams{"limit": {"10"}, "sort": {"name"}})
+	assert.Nil(t, err)
+	resp, err := l.Get(testFlatResource(25), args)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, resp.NextCursor)
+
+	_, err = ListGetArgsFromParams(testParams{"sort": {"size"}, "cursor": {resp.NextCursor}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+	_, err = ListGetArgsFromParams(testParams{"sort": {"name"}, "path": {"dir"}, "cursor": {resp.NextCursor}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+	_, err = ListGetArgsFromParams(testParams{"sort": {"name"}, "offset": {"10"}, "cursor": {resp.NextCursor}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+	_, err = ListGetArgsFromParams(testParams{"cursor": {"not a cursor"}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+}
+
+func TestListGetArgsFromParams_cursorLimit(t *testing.T) {
+	args, err := ListGetArgsFromParams(testParams{"limit": {"10"}})
+	assert.Nil(t, err)
+	resp, err := NewList().Get(testFlatResource(25), args)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, resp.NextCursor)
+
+	_, err = ListGetArgsFromParams(testParams{"limit": {"5000"}, "cursor": {resp.NextCursor}})
+	assert.Nil(t, err)
+	_, err = ListGetArgsFromParams(testParams{"limit": {"10001"}, "cursor": {resp.NextCursor}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+	_, err = ListGetArgsFromParams(testParams{"limit": {"5000"}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+	_, err = ListGetArgsFromParams(testParams{"limit": {"5000"}, "offset": {"0"}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testParams url.Values
+
+func (s testParams) Param(_ string) string        { return "" }
+func (s testParams) Query(k string) string        { return url.Values(s).Get(k) }
+func (s testParams) QueryArray(k string) []string { return url.Values(s)[k] }
+func (s testParams) GetHeader(_ string) string    { return "" }
+
+func testSeasonResource() *Resource {
+	return &Resource{
+		Files: []*File{
+			{Path: []string{"Show", "Season 1", "Show.S01E01.mkv"}, Size: 100},
+			{Path: []string{"Show", "Season 1", "Show.S01E01.srt"}, Size: 1},
+			{Path: []string{"Show", "Season 1", "Show.S01E02.mp4"}, Size: 200},
+			{Path: []string{"Show", "Season 2", "Show.S02E01.MKV"}, Size: 300},
+			{Path: []string{"Show", "Extras", "cover.jpg"}, Size: 5},
+			{Path: []string{"Show", "readme.txt"}, Size: 2},
+		},
+	}
+}
+
+func listNames(items []ListItem) []string {
+	var res []string
+	for _, i := range items {
+		res = append(res, i.Name)
+	}
+	return res
+}
+
+func TestListGetArgsFromParams_filters(t *testing.T) {
+	args, err := ListGetArgsFromParams(testParams{
+		"q":            {" S01* "},
+		"media_format": {"video,audio", "subtitle"},
+		"ext":          {".MKV,mp4"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "s01*", args.Query)
+	assert.Equal(t, []MediaFormat{Video, Audio, Subtitle}, args.MediaFormats)
+	assert.Equal(t, []string{"mkv", "mp4"}, args.Exts)
+
+	_, err = ListGetArgsFromParams(testParams{"media_format": {"archive"}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+	_, err = ListGetArgsFromParams(testParams{"q": {"[s01"}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+}
+
+func TestList_buildList_filters(t *testing.T) {
+	l := NewList()
+	r := testSeasonResource()
+
+	resp := l.buildList(r, &ListGetArgs{Path: []string{}, Query: "s01e01"})
+	assert.Equal(t, []string{"Show", "Season 1", "Show.S01E01.mkv", "Show.S01E01.srt"}, listNames(resp.Items))
+	assert.Equal(t, int64(101), resp.Size)
+
+	resp = l.buildList(r, &ListGetArgs{Path: []string{}, Query: "*e01.mkv"})
+	assert.Equal(t, []string{"Show", "Season 1", "Show.S01E01.mkv", "Season 2", "Show.S02E01.MKV"}, listNames(resp.Items))
+
+	resp = l.buildList(r, &ListGetArgs{Path: []string{}, MediaFormats: []MediaFormat{Video}, Exts: []string{"mp4"}})
+	assert.Equal(t, []string{"Show", "Season 1", "Show.S01E02.mp4"}, listNames(resp.Items))
+	assert.Equal(t, 3, resp.Count)
+}
+
+func TestList_buildTree_filtersKeepMatchingDirs(t *testing.T) {
+	l := NewList()
+	r := testSeasonResource()
+
+	resp := l.buildTree(r, &ListGetArgs{Path: []string{"Show"}, MediaFormats: []MediaFormat{Video}})
+	assert.Equal(t, []string{"Season 1", "Season 2"}, listNames(resp.Items))
+	assert.Equal(t, int64(300), resp.Items[0].Size)
+
+	resp = l.buildTree(r, &ListGetArgs{Path: []string{"Show"}, Query: "readme"})
+	assert.Equal(t, []string{"readme.txt"}, listNames(resp.Items))
+}
@@ -105,3 +105,74 @@ func TestList_sortItems_SizeTieBreaker(t *testing.T) {
 	assert.Equal(t, "apple.txt", items[0].Name)
 	assert.Equal(t, "zebra.txt", items[1].Name)
 }
+
+func TestList_sortItems_Natural(t *testing.T) {
+	l := NewList()
+
+	items := []ListItem{
+		{Name: "Episode 10.mkv", Type: ListTypeFile},
+		{Name: "episode 2.mkv", Type: ListTypeFile},
+		{Name: "Episode 1.mkv", Type: ListTypeFile},
+		{Name: "Episode 02.srt", Type: ListTypeFile},
+	}
+
+	l.sortItems(items, ListSortTypeName)
+
+	assert.Equal(t, "Episode 1.mkv", items[0].Name)
+	assert.Equal(t, "episode 2.mkv", items[1].Name)
+	assert.Equal(t, "Episode 02.srt", items[2].Name)
+	assert.Equal(t, "Episode 10.mkv", items[3].Name)
+}
+
+func TestList_sortItems_IndexKeepsTorrentOrder(t *testing.T) {
+	l := NewList()
+
+	items := []ListItem{
+		{Name: "b.mkv", Type: ListTypeFile, Index: 2},
+		{Name: "zFolder", Type: ListTypeDirectory},
+		{Name: "c.mkv", Type: ListTypeFile, Index: 0},
+		{Name: "aFolder", Type: ListTypeDirectory},
+		{Name: "a.mkv", Type: ListTypeFile, Index: 1},
+	}
+
+	l.sortItems(items, ListSortTypeIndex)
+
+	assert.Equal(t, "zFolder", items[0].Name)
+	assert.Equal(t, "aFolder", items[1].Name)
+	assert.Equal(t, "c.mkv", items[2].Name)
+	assert.Equal(t, "a.mkv", items[3].Name)
+	assert.Equal(t, "b.mkv", items[4].Name)
+}
+
+func TestList_sortItemsWithOrder(t *testing.T) {
+	l := NewList()
+
+	items := []ListItem{
+		{Name: "movie.mkv", Type: ListTypeFile, Ext: "mkv", MediaFormat: Video, Size: 300},
+		{Name: "cover.jpg", Type: ListTypeFile, Ext: "jpg", MediaFormat: Image, Size: 100},
+		{Name: "movie.srt", Type: ListTypeFile, Ext: "srt", MediaFormat: Subtitle, Size: 200},
+		{Name: "Extras", Type: ListTypeDirectory},
+	}
+
+	l.sortItemsWithOrder(items, ListSortTypeExt, ListOrderDesc)
+	assert.Equal(t, []string{"Extras", "movie.srt", "movie.mkv", "cover.jpg"}, listNames(items))
+
+	l.sortItemsWithOrder(items, ListSortTypeMediaFormat, ListOrderAsc)
+	assert.Equal(t, []string{"Extras", "cover.jpg", "movie.srt", "movie.mkv"}, listNames(items))
+
+	l.sortItemsWithOrder(items, ListSortTypeSize, ListOrderAsc)
+	assert.Equal(t, []string{"Extras", "cover.jpg", "movie.srt", "movie.mkv"}, listNames(items))
+
+	l.sortItemsWithOrder(items, ListSortTypeName, ListOrderDesc)
+	assert.Equal(t, []string{"Extras", "movie.srt", "movie.mkv", "cover.jpg"}, listNames(items))
+}
+
+func TestListGetArgsFromParams_order(t *testing.T) {
+	args, err := ListGetArgsFromParams(testParams{"sort": {"media_format"}, "order": {"desc"}})
+	assert.Nil(t, err)
+	assert.Equal(t, ListSortTypeMediaFormat, args.Sort)
+	assert.Equal(t, ListOrderDesc, args.Order)
+
+	_, err = ListGetArgsFromParams(testParams{"order": {"up"}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+}
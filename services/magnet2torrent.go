@@ -10,6 +10,7 @@ import (
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	m2t "github.com/webtor-io/magnet2torrent/magnet2torrent"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 )
 
@@ -57,6 +58,8 @@ func (s *Magnet2Torrent) get() (m2t.Magnet2TorrentClient, error) {
 	addr := fmt.Sprintf("%s:%d", s.host, s.port)
 	conn, err := grpc.NewClient(addr,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(grpcMetricsInterceptor("magnet2torrent")),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 		grpc.WithDefaultCallOptions(
 			grpc.MaxCallRecvMsgSize(magnet2torrentMaxMsgSize),
 			grpc.MaxCallSendMsgSize(magnet2torrentMaxMsgSize),
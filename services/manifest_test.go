@@ -51,8 +51,9 @@ func TestGetManifestNotFound(t *testing.T) {
 
 	_, err := rm.GetManifest(context.Background(), manifestHash)
 	assert.NotNil(err)
-	// Message must contain "not found" so the web error handler maps it to 404.
 	assert.Contains(err.Error(), "not found")
+	// The web error handler maps by type, not by message.
+	assert.Equal(ErrorCodeNotFound, ErrorCodeOf(err))
 }
 
 func TestGetManifestForbidden(t *testing.T) {
@@ -65,8 +66,8 @@ func TestGetManifestForbidden(t *testing.T) {
 
 	_, err := rm.GetManifest(context.Background(), manifestHash)
 	assert.NotNil(err)
-	// "forbidden" maps to 403 in the web error handler.
 	assert.Contains(err.Error(), "forbidden")
+	assert.Equal(ErrorCodeForbidden, ErrorCodeOf(err))
 }
 
 func TestMagnetFromInfoHash(t *testing.T) {
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Metrics are registered in the default Prometheus registry, which is what
+// common-services' Prom serves on its own listener.
+
+var (
+	promHTTPRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webtor_rest_api_http_requests_total",
+		Help: "Total number of HTTP requests by route and status",
+	}, []string{"method", "route", "status"})
+	promHTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "webtor_rest_api_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by route",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	promGRPCCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "webtor_rest_api_grpc_client_call_duration_seconds",
+		Help: "Duration of outgoing gRPC calls by service, method and status code",
+		// Magnet2Torrent calls last up to magnetTimeout (3 minutes).
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 180},
+	}, []string{"service", "method", "code"})
+	promResourceErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webtor_rest_api_resource_errors_total",
+		Help: "Total number of failed resource loads by operation and error code",
+	}, []string{"op", "code"})
+	promCacheProbes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webtor_rest_api_cache_probes_total",
+		Help: "Total number of cache probes by result (hit, miss, failure)",
+	}, []string{"result"})
+	promNodes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webtor_rest_api_nodes",
+		Help: "Number of ready nodes per pool",
+	}, []string{"pool"})
+)
+
+// lazyMapSizes reports the current size of every registered lazymap. Maps
+// are keyed by name, so a map built again (as tests do) replaces the
+// previous one instead of failing registration.
+type lazyMapSizeCollector struct {
+	mux  sync.RWMutex
+	desc *prometheus.Desc
+	lens map[string]func() int
+}
+
+var lazyMapSizes = &lazyMapSizeCollector{
+	desc: prometheus.NewDesc(
+		"webtor_rest_api_lazymap_size",
+		"Number of entries held by the lazymap cache",
+		[]string{"name"}, nil,
+	),
+	lens: map[string]func() int{},
+}
+
+func init() {
+	prometheus.MustRegister(lazyMapSizes)
+}
+
+func (s *lazyMapSizeCollector) add(name string, l func() int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.lens[name] = l
+}
+
+func (s *lazyMapSizeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.desc
+}
+
+func (s *lazyMapSizeCollector) Collect(ch chan<- prometheus.Metric) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	for name, l := range s.lens {
+		ch <- prometheus.MustNewConstMetric(s.desc, prometheus.GaugeValue, float64(l()), name)
+	}
+}
+
+// observeResourceError counts a failed ResourceMap operation by its
+// ErrorCode.
+func observeResourceError(op string, err error) {
+	if err == nil {
+		return
+	}
+	promResourceErrors.WithLabelValues(op, string(ErrorCodeOf(err))).Inc()
+}
+
+// grpcMetricsInterceptor records the duration and status code of every
+// unary call made through the client connection.
+func grpcMetricsInterceptor(service string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		promGRPCCallDuration.WithLabelValues(service, method, status.Code(err).String()).
+			Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// metricsHandler is the gin middleware feeding the HTTP request metrics.
+// Routes are labeled by their pattern, not by the actual path, to keep
+// cardinality bounded.
+func metricsHandler(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	promHTTPRequestsTotal.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	promHTTPRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+}
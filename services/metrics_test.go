@@ -0,0 +1,43 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheMapGetCountsProbes(t *testing.T) {
+	assert := assert.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hit" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	hits := testutil.ToFloat64(promCacheProbes.WithLabelValues("hit"))
+	misses := testutil.ToFloat64(promCacheProbes.WithLabelValues("miss"))
+	cm := newTestCacheMap(srv.Client(), time.Second)
+	_, _ = cm.Get(context.Background(), testCacheMapURL(t, srv.URL, "/hit"))
+	_, _ = cm.Get(context.Background(), testCacheMapURL(t, srv.URL, "/miss"))
+	// Memoized answers are not probes.
+	_, _ = cm.Get(context.Background(), testCacheMapURL(t, srv.URL, "/hit"))
+	assert.Equal(hits+1, testutil.ToFloat64(promCacheProbes.WithLabelValues("hit")))
+	assert.Equal(misses+1, testutil.ToFloat64(promCacheProbes.WithLabelValues("miss")))
+}
+
+func TestLazyMapSizes(t *testing.T) {
+	rm := NewTestResourceMap()
+	_, _ = rm.LazyMap.Get("k", func() (*Resource, error) {
+		return &Resource{}, nil
+	})
+	assert.GreaterOrEqual(t, testutil.CollectAndCount(lazyMapSizes), 2)
+	assert.Equal(t, 1, lazyMapSizes.lens["resources"]())
+}
@@ -1,5 +1,7 @@
 package services
 
+import "time"
+
 type ResourceResponse struct {
 	ID        string `json:"id"`
 	Name      string `json:"name,omitempty"`
@@ -21,6 +23,55 @@ type ResourceResponse struct {
 
 type ErrorResponse struct {
 	Error string `json:"error"`
+	// Code is the stable machine-readable kind of the error (bad_request,
+	// unauthorized, invalid_token, forbidden, not_found, quota_exceeded,
+	// rate_limited, upstream_timeout, upstream_unavailable, internal).
+	// Unlike Error it never changes with the wording.
+	Code ErrorCode `json:"code"`
+}
+
+// ResourceJobResponse reports the state of a background resolution started
+// with POST /resource/?async=true. Resource is set once the job is resolved,
+// Error once it has failed.
+type ResourceJobResponse struct {
+	ID       string            `json:"id"`
+	Status   ResourceJobStatus `json:"status"`
+	Resource *ResourceResponse `json:"resource,omitempty"`
+	Error    *ErrorResponse    `json:"error,omitempty"`
+}
+
+// ResourceEvent is the payload of intermediate events of
+// /resource/{resource_id}/resolve/events.
+type ResourceEvent struct {
+	ID    string        `json:"id"`
+	Stage ResourceStage `json:"stage"`
+}
+
+// BatchResourceItem is the result for one entry of POST /resource/batch.
+// Status is the HTTP status the entry would have got from POST /resource/;
+// exactly one of Resource and Error is set.
+type BatchResourceItem struct {
+	Status   int               `json:"status"`
+	Resource *ResourceResponse `json:"resource,omitempty"`
+	Error    *ErrorResponse    `json:"error,omitempty"`
+}
+
+// ExportBatchRequest is the body of POST /resource/{resource_id}/export.
+type ExportBatchRequest struct {
+	ContentIDs []string `json:"content_ids"`
+	// Recursive expands directory content ids to every file under them.
+	Recursive bool `json:"recursive,omitempty"`
+}
+
+// ExportBatchItem is the result for one content of
+// POST /resource/{resource_id}/export. ID is the requested content id, or
+// the file id for items expanded from a directory; exactly one of Export and
+// Error is set.
+type ExportBatchItem struct {
+	ID     string          `json:"id"`
+	Status int             `json:"status"`
+	Export *ExportResponse `json:"export,omitempty"`
+	Error  *ErrorResponse  `json:"error,omitempty"`
 }
 
 type ListType string
@@ -31,12 +82,14 @@ const (
 )
 
 type ListItem struct {
-	ID          string      `json:"id"`
-	Name        string      `json:"name,omitempty"`
-	PathStr     string      `json:"path"`
-	Path        []string    `json:"-"`
-	Type        ListType    `json:"type"`
-	Size        int64       `json:"size"`
+	ID      string   `json:"id"`
+	Name    string   `json:"name,omitempty"`
+	PathStr string   `json:"path"`
+	Path    []string `json:"-"`
+	Type    ListType `json:"type"`
+	Size    int64    `json:"size"`
+	// FilesCount is the number of files under a directory item.
+	FilesCount  int         `json:"files_count,omitempty"`
 	MediaFormat MediaFormat `json:"media_format,omitempty"`
 	MimeType    string      `json:"mime_type,omitempty"`
 	Ext         string      `json:"ext,omitempty"`
@@ -50,15 +103,19 @@ type ListItem struct {
 
 type ListResponse struct {
 	ListItem
-	Items []ListItem `json:"items"`
-	Count int        `json:"items_count"`
+	Items      []ListItem `json:"items"`
+	Count      int        `json:"items_count"`
+	NextCursor string     `json:"next_cursor,omitempty"`
 }
 
 type ExportItem struct {
 	ExportStreamItem
 	ExportMetaItem
+	ExportPlaylistItem
 	Type string `json:"-"`
 	URL  string `json:"url,omitempty"`
+	// expiresAt is when the item's urls expire, zero if never.
+	expiresAt time.Time
 }
 
 type ExportSource struct {
@@ -71,6 +128,14 @@ type ExportTrack struct {
 	Kind    ExportKindType `json:"kind"`
 	SrcLang string         `json:"srclang,omitempty"`
 	Label   string         `json:"label,omitempty"`
+	// Forced tracks only translate foreign dialogue and signs.
+	Forced bool `json:"forced,omitempty"`
+	// SDH tracks also describe sounds, for the deaf and hard of hearing.
+	// Their kind is captions.
+	SDH bool `json:"sdh,omitempty"`
+	// Default is set on the track in the language asked for by the lang
+	// query or Accept-Language header.
+	Default bool `json:"default,omitempty"`
 }
 
 type ExportPreloadType string
@@ -84,6 +149,7 @@ type ExportKindType string
 
 const (
 	ExportKindTypeSubtitles ExportKindType = "subtitles"
+	ExportKindTypeCaptions  ExportKindType = "captions"
 )
 
 type ExportTagName string
@@ -112,16 +178,35 @@ type ExportMetaItem struct {
 	Meta *ExportMeta `json:"meta,omitempty"`
 }
 
+type ExportPlaylistItem struct {
+	Playlist *ExportPlaylist `json:"playlist,omitempty"`
+}
+
+// ExportPlaylist is a playlist of the playable files of a directory, inline
+// in the export response. The same body is served as a file by
+// /resource/{resource_id}/playlist/{content_id}.
+type ExportPlaylist struct {
+	Format   PlaylistFormat `json:"format"`
+	MimeType string         `json:"mime_type"`
+	Body     string         `json:"body"`
+}
+
 type ExportMeta struct {
 	Transcode      bool `json:"transcode,omitempty"`
 	Multibitrate   bool `json:"multibitrate,omitempty"`
 	Cache          bool `json:"cache,omitempty"`
 	TranscodeCache bool `json:"transcode_cache,omitempty"`
+	// Role is the role the urls were minted for. It is the export api role
+	// when an invalid token was ignored.
+	Role string `json:"role,omitempty"`
 }
 
 type ExportResponse struct {
 	Source      ListItem              `json:"source"`
 	ExportItems map[string]ExportItem `json:"exports"`
+	// ExpiresAt is when the first of the exported urls expires, omitted if
+	// they never do.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 type Subtitles struct {
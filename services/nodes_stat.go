@@ -10,6 +10,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/urfave/cli"
 	"github.com/webtor-io/lazymap"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -75,15 +76,20 @@ func NewNodesStat(c *cli.Context, kcl *K8SClient) *NodesStat {
 	}
 }
 
-func (s *NodesStat) Get() ([]NodeStat, error) {
+// Get returns the ready nodes. The nodes list is shared by every caller
+// until it expires, so it keeps its own deadline, but is traced under the
+// request that triggered it.
+func (s *NodesStat) Get(ctx context.Context) ([]NodeStat, error) {
 	return s.LazyMap.Get("", func() ([]NodeStat, error) {
-		ctx2, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx2, cancel := context.WithTimeout(detachedSpanContext(ctx), 10*time.Second)
 		defer cancel()
 		cl, err := s.kcl.Get()
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to get k8s client")
 		}
+		ctx2, span := tracer.Start(ctx2, "k8s.Nodes.List", trace.WithSpanKind(trace.SpanKindClient))
 		nodes, err := cl.CoreV1().Nodes().List(ctx2, metav1.ListOptions{})
+		endSpan(span, err)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to get nodes")
 		}
@@ -120,10 +126,24 @@ func (s *NodesStat) Get() ([]NodeStat, error) {
 		sort.Slice(res, func(i, j int) bool {
 			return res[i].Name < res[j].Name
 		})
+		s.observeNodes(res)
 		return res, nil
 	})
 }
 
+func (s *NodesStat) observeNodes(stats []NodeStat) {
+	pools := map[string]int{}
+	for _, st := range stats {
+		for _, p := range st.Pools {
+			pools[p]++
+		}
+	}
+	promNodes.Reset()
+	for p, n := range pools {
+		promNodes.WithLabelValues(p).Set(float64(n))
+	}
+}
+
 func (s *NodesStat) getLabelList(n corev1.Node, name string) []string {
 	var list []string
 	if v, ok := n.GetLabels()[fmt.Sprintf("%v%v", s.labelPrefix, name)]; ok {
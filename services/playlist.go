@@ -0,0 +1,82 @@
+package services
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+type PlaylistFormat string
+
+const (
+	PlaylistFormatM3U8 PlaylistFormat = "m3u8"
+	PlaylistFormatXSPF PlaylistFormat = "xspf"
+)
+
+// maxPlaylistEntries bounds the number of files a playlist is built of,
+// every entry costs a stream url with its cache probes.
+const maxPlaylistEntries = 1000
+
+func PlaylistFormatFromParams(g ParamGetter) (PlaylistFormat, error) {
+	switch f := PlaylistFormat(g.Query("playlist-format")); f {
+	case "", PlaylistFormatM3U8:
+		return PlaylistFormatM3U8, nil
+	case PlaylistFormatXSPF:
+		return f, nil
+	default:
+		return "", errBadRequest("failed to parse playlist format \"%v\"", f)
+	}
+}
+
+func (s PlaylistFormat) MimeType() string {
+	if s == PlaylistFormatXSPF {
+		return "application/xspf+xml"
+	}
+	return "audio/x-mpegurl"
+}
+
+type PlaylistEntry struct {
+	Title string
+	URL   string
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version int         `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title"`
+}
+
+// renderPlaylist renders entries as an extended M3U or an XSPF playlist.
+func renderPlaylist(f PlaylistFormat, title string, entries []PlaylistEntry) (string, error) {
+	if f == PlaylistFormatXSPF {
+		p := xspfPlaylist{Version: 1, Title: title}
+		for _, e := range entries {
+			p.Tracks = append(p.Tracks, xspfTrack{Location: e.URL, Title: e.Title})
+		}
+		b, err := xml.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return xml.Header + string(b) + "\n", nil
+	}
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	if title != "" {
+		sb.WriteString("#PLAYLIST:" + playlistLine(title) + "\n")
+	}
+	for _, e := range entries {
+		sb.WriteString("#EXTINF:-1," + playlistLine(e.Title) + "\n")
+		sb.WriteString(e.URL + "\n")
+	}
+	return sb.String(), nil
+}
+
+// playlistLine keeps a title on its M3U line.
+func playlistLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
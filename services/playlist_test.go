@@ -0,0 +1,115 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func testAlbumResource() *Resource {
+	return &Resource{
+		ID:   manifestHash,
+		Name: "Album",
+		Files: []*File{
+			{Path: []string{"Album", "CD1", "10 - Ten.mp3"}, Size: 1},
+			{Path: []string{"Album", "CD1", "2 - Two.mp3"}, Size: 1},
+			{Path: []string{"Album", "cover.jpg"}, Size: 1},
+			{Path: []string{"Album", "notes.txt"}, Size: 1},
+		},
+	}
+}
+
+func TestPlaylistExporter_Export(t *testing.T) {
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer probe.Close()
+	ub := &URLBuilder{
+		cm:         newTestCacheMap(probe.Client(), time.Second),
+		domain:     probe.URL,
+		pathPrefix: "/",
+		tk:         testTokens("", 0),
+	}
+	e := NewPlaylistExporter(ub)
+	r := testAlbumResource()
+	dir, _ := r.Index().Path("/Album")
+
+	ei, err := e.Export(r, &dir, testParams{"token": {"t"}})
+	if !assert.Nil(t, err) || !assert.NotNil(t, ei) {
+		return
+	}
+	assert.Equal(t, "playlist", ei.Type)
+	assert.Equal(t, PlaylistFormatM3U8, ei.Playlist.Format)
+	assert.Equal(t, "audio/x-mpegurl", ei.Playlist.MimeType)
+	lines := strings.Split(strings.TrimSpace(ei.Playlist.Body), "\n")
+	if assert.Len(t, lines, 6) {
+		assert.Equal(t, "#EXTM3U", lines[0])
+		assert.Equal(t, "#PLAYLIST:Album", lines[1])
+		assert.Equal(t, "#EXTINF:-1,2 - Two", lines[2])
+		assert.Equal(t, probe.URL+"/"+manifestHash+"/Album/CD1/2%20-%20Two.mp3?token=t", lines[3])
+		assert.Equal(t, "#EXTINF:-1,10 - Ten", lines[4])
+	}
+
+	ei, err = e.Export(r, &dir, testParams{"playlist-format": {"xspf"}})
+	if assert.Nil(t, err) {
+		assert.Equal(t, "application/xspf+xml", ei.Playlist.MimeType)
+		assert.Contains(t, ei.Playlist.Body, `<playlist xmlns="http://xspf.org/ns/0/" version="1">`)
+		assert.Contains(t, ei.Playlist.Body, "<title>2 - Two</title>")
+	}
+
+	_, err = e.Export(r, &dir, testParams{"playlist-format": {"pls"}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+
+	f, _ := r.Index().Path("/Album/cover.jpg")
+	ei, err = e.Export(r, &f, testParams{})
+	assert.Nil(t, err)
+	assert.Nil(t, ei)
+}
+
+func TestExportGetArgsFromParams_playlistOptIn(t *testing.T) {
+	args, err := ExportGetArgsFromParams(testParams{})
+	assert.Nil(t, err)
+	assert.NotContains(t, args.Types, ExportTypePlaylist)
+	args, err = ExportGetArgsFromParams(testParams{"types": {"download,playlist"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []ExportType{ExportTypeDownload, ExportTypePlaylist}, args.Types)
+}
+
+func TestWeb_getPlaylist(t *testing.T) {
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer probe.Close()
+	ub := &URLBuilder{
+		cm:         newTestCacheMap(probe.Client(), time.Second),
+		domain:     probe.URL,
+		pathPrefix: "/",
+		tk:         testTokens("", 0),
+	}
+	rm := NewTestResourceMap()
+	r := testAlbumResource()
+	_, _ = rm.manifests.Get(manifestHash, func() (*Resource, error) { return r, nil })
+	w := &Web{rm: rm, c: NewList(), e: NewExport(NewPlaylistExporter(ub))}
+	gr := gin.New()
+	gr.Use(w.errorHandler)
+	gr.GET("/resource/:resource_id/playlist/:content_id", w.getPlaylist)
+
+	get := func(p string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		gr.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/resource/"+manifestHash+"/playlist/"+p, nil))
+		return rec
+	}
+	dir, _ := r.Index().Path("/Album")
+	rec := get(dir.ID + "?playlist-format=xspf")
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.Equal(t, "application/xspf+xml", rec.Header().Get("Content-Type"))
+		assert.Equal(t, `inline; filename="Album.xspf"`, rec.Header().Get("Content-Disposition"))
+	}
+	notes, _ := r.Index().Path("/Album/notes.txt")
+	assert.Equal(t, http.StatusBadRequest, get(notes.ID).Code)
+}
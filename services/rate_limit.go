@@ -0,0 +1,275 @@
+package services
+
+import (
+	"container/list"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	rateLimitCreateFlag = "rate-limit-create"
+	rateLimitListFlag   = "rate-limit-list"
+	rateLimitExportFlag = "rate-limit-export"
+)
+
+func RegisterRateLimitFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   rateLimitCreateFlag,
+			Usage:  "rate limit of resource creation per client, like 10/1m, unlimited if empty",
+			Value:  "",
+			EnvVar: "RATE_LIMIT_CREATE",
+		},
+		cli.StringFlag{
+			Name:   rateLimitListFlag,
+			Usage:  "rate limit of resource and content listing per client, like 600/1m, unlimited if empty",
+			Value:  "",
+			EnvVar: "RATE_LIMIT_LIST",
+		},
+		cli.StringFlag{
+			Name:   rateLimitExportFlag,
+			Usage:  "rate limit of exports per client, like 300/1m, unlimited if empty",
+			Value:  "",
+			EnvVar: "RATE_LIMIT_EXPORT",
+		},
+	)
+}
+
+// RateBudget is a group of routes sharing a rate limit.
+type RateBudget string
+
+const (
+	RateBudgetCreate RateBudget = "create"
+	RateBudgetList   RateBudget = "list"
+	RateBudgetExport RateBudget = "export"
+)
+
+// RateLimit is a token bucket of Burst requests, refilled over Period.
+type RateLimit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// ParseRateLimit parses a rate limit like 10/1m.
+func ParseRateLimit(v string) (RateLimit, error) {
+	n, p, ok := strings.Cut(v, "/")
+	if !ok {
+		return RateLimit{}, errors.Errorf("failed to parse rate limit %q, should be like 10/1m", v)
+	}
+	burst, err := strconv.Atoi(n)
+	if err != nil || burst <= 0 {
+		return RateLimit{}, errors.Errorf("failed to parse rate limit %q, should be like 10/1m", v)
+	}
+	period, err := time.ParseDuration(p)
+	if err != nil || period <= 0 {
+		return RateLimit{}, errors.Errorf("failed to parse rate limit %q, should be like 10/1m", v)
+	}
+	return RateLimit{Burst: burst, Period: period}, nil
+}
+
+// RateLimitResult is the state of a bucket after a request was counted.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, zero if
+	// it is right away.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the token buckets. It is in memory for a single
+// instance; a shared store lets replicas enforce one limit.
+type RateLimitStore interface {
+	// Take takes n tokens from the bucket of key, all of them or none.
+	Take(key string, l RateLimit, n int, now time.Time) RateLimitResult
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+	fullAt time.Time
+}
+
+const (
+	// rateLimitSweepInterval is how often full buckets are dropped from
+	// memory.
+	rateLimitSweepInterval = time.Minute
+	// rateLimitMaxBuckets caps the buckets kept in memory, whatever number
+	// of clients shows up between sweeps.
+	rateLimitMaxBuckets = 100000
+)
+
+// MemoryRateLimitStore is a RateLimitStore kept in memory. Once it holds
+// capacity buckets, a new client's bucket replaces the one idle the
+// longest, which has refilled the most and is the least missed.
+type MemoryRateLimitStore struct {
+	mux     sync.Mutex
+	buckets map[string]*list.Element
+	// idle orders the buckets by last use, most recent first.
+	idle      *list.List
+	capacity  int
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:  map[string]*list.Element{},
+		idle:     list.New(),
+		capacity: rateLimitMaxBuckets,
+	}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, l RateLimit, n int, now time.Time) RateLimitResult {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.sweep(now)
+	burst := float64(l.Burst)
+	rate := burst / l.Period.Seconds()
+	var b *tokenBucket
+	if e, ok := s.buckets[key]; ok {
+		s.idle.MoveToFront(e)
+		b = e.Value.(*tokenBucket)
+		if now.After(b.last) {
+			b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+			b.last = now
+		}
+	} else {
+		if len(s.buckets) >= s.capacity {
+			s.remove(s.idle.Back())
+		}
+		b = &tokenBucket{key: key, tokens: burst, last: now}
+		s.buckets[key] = s.idle.PushFront(b)
+	}
+	res := RateLimitResult{Limit: l.Burst}
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		res.Allowed = true
+	} else {
+		res.RetryAfter = rateDuration((float64(n) - b.tokens) / rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = rateDuration((burst - b.tokens) / rate)
+	b.fullAt = now.Add(res.Reset)
+	return res
+}
+
+// sweep drops the buckets that are full again, they are the same as no
+// bucket at all.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for _, e := range s.buckets {
+		if !e.Value.(*tokenBucket).fullAt.After(now) {
+			s.remove(e)
+		}
+	}
+}
+
+func (s *MemoryRateLimitStore) remove(e *list.Element) {
+	s.idle.Remove(e)
+	delete(s.buckets, e.Value.(*tokenBucket).key)
+}
+
+func rateDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
+
+// RateLimiter limits the requests of every client per RateBudget. Clients
+// are told apart by authenticated api key, by the user id of a verified
+// token or, failing both, by ip.
+type RateLimiter struct {
+	store  RateLimitStore
+	tk     *Tokens
+	limits map[RateBudget]RateLimit
+	now    func() time.Time
+}
+
+func NewRateLimiter(c *cli.Context, store RateLimitStore, tk *Tokens) (*RateLimiter, error) {
+	limits := map[RateBudget]RateLimit{}
+	for b, f := range map[RateBudget]string{
+		RateBudgetCreate: rateLimitCreateFlag,
+		RateBudgetList:   rateLimitListFlag,
+		RateBudgetExport: rateLimitExportFlag,
+	} {
+		v := c.String(f)
+		if v == "" {
+			continue
+		}
+		l, err := ParseRateLimit(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %v", f)
+		}
+		limits[b] = l
+	}
+	if len(limits) == 0 {
+		return nil, nil
+	}
+	return &RateLimiter{
+		store:  store,
+		tk:     tk,
+		limits: limits,
+		now:    time.Now,
+	}, nil
+}
+
+// clientKey identifies the client of the request. Only what the client
+// can't make up tells clients apart: an api key checked by Auth, or the
+// user id of a token verified here.
+func (s *RateLimiter) clientKey(g *gin.Context) string {
+	if k := authKey(g); k != nil {
+		return "key:" + k.Key
+	}
+	if t := getRequestToken(g); t != "" && s.tk != nil {
+		if claims, err := s.tk.Parse(t); err == nil {
+			if id, _ := claims["user_id"].(string); id != "" {
+				return "user:" + id
+			}
+		}
+	}
+	return "ip:" + g.ClientIP()
+}
+
+// Take counts n requests of the client against budget b and sets the rate
+// limit headers. It returns an error if the limit is exceeded.
+func (s *RateLimiter) Take(g *gin.Context, b RateBudget, n int) error {
+	l, ok := s.limits[b]
+	if !ok {
+		return nil
+	}
+	if n > l.Burst {
+		return errRateLimited("%d %v requests at once exceed the rate limit of %d per %v", n, b, l.Burst, l.Period)
+	}
+	res := s.store.Take(string(b)+"|"+s.clientKey(g), l, n, s.now())
+	g.Header("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+	g.Header("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	g.Header("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(res.Reset.Seconds()))))
+	if !res.Allowed {
+		g.Header("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+		return errRateLimited("rate limit of %d %v requests per %v exceeded", l.Burst, b, l.Period)
+	}
+	return nil
+}
+
+// Handler returns the gin middleware counting requests against budget b.
+func (s *RateLimiter) Handler(b RateBudget) gin.HandlerFunc {
+	return func(g *gin.Context) {
+		if err := s.Take(g, b, 1); err != nil {
+			_ = g.Error(err)
+			g.Abort()
+			return
+		}
+		g.Next()
+	}
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	l, err := ParseRateLimit("10/1m")
+	if assert.Nil(t, err) {
+		assert.Equal(t, RateLimit{Burst: 10, Period: time.Minute}, l)
+	}
+	for _, v := range []string{"10", "0/1m", "ten/1m", "10/minute", "10/-1s"} {
+		_, err = ParseRateLimit(v)
+		assert.NotNil(t, err, v)
+	}
+}
+
+func TestMemoryRateLimitStore_Take(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	l := RateLimit{Burst: 2, Period: 10 * time.Second}
+	now := time.Now()
+
+	res := s.Take("a", l, 1, now)
+	assert.Equal(t, RateLimitResult{Allowed: true, Limit: 2, Remaining: 1, Reset: 5 * time.Second}, res)
+	res = s.Take("a", l, 1, now)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	res = s.Take("a", l, 1, now)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 5*time.Second, res.RetryAfter)
+	assert.Equal(t, 10*time.Second, res.Reset)
+
+	// Other keys have buckets of their own.
+	assert.True(t, s.Take("b", l, 1, now).Allowed)
+
+	// A token is back every Period/Burst.
+	res = s.Take("a", l, 1, now.Add(5*time.Second))
+	assert.True(t, res.Allowed)
+	assert.False(t, s.Take("a", l, 1, now.Add(5*time.Second)).Allowed)
+
+	// Tokens are taken all at once or not at all.
+	assert.False(t, s.Take("b", l, 2, now).Allowed)
+	assert.True(t, s.Take("b", l, 1, now).Allowed)
+
+	// Full buckets are swept.
+	s.Take("c", l, 1, now.Add(time.Hour))
+	assert.Len(t, s.buckets, 1)
+
+	// New clients past capacity replace the one idle the longest.
+	s.capacity = 2
+	later := now.Add(time.Hour)
+	s.Take("d", l, 2, later)
+	s.Take("c", l, 1, later)
+	s.Take("e", l, 1, later)
+	assert.Len(t, s.buckets, 2)
+	assert.NotContains(t, s.buckets, "d")
+	assert.False(t, s.Take("c", l, 1, later).Allowed)
+}
+
+func TestRateLimiter_Handler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rl := &RateLimiter{
+		store: NewMemoryRateLimitStore(),
+		limits: map[RateBudget]RateLimit{
+			RateBudgetCreate: {Burst: 1, Period: time.Minute},
+		},
+		now: time.Now,
+	}
+	rl.tk = testTokens("secret", 0)
+	w := &Web{rl: rl}
+	r := gin.New()
+	r.Use(w.errorHandler)
+	ok := func(g *gin.Context) {
+		g.Status(http.StatusOK)
+	}
+	authed := func(g *gin.Context) {
+		if k := g.GetHeader("X-Api-Key"); k == "known" {
+			g.Set(authKeyContextKey, &APIKey{Key: k})
+		}
+	}
+	r.POST("/resource/", authed, w.limit(RateBudgetCreate), ok)
+	r.GET("/resource/:resource_id", w.limit(RateBudgetList), ok)
+	do := func(method string, path string, header map[string]string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/resource/", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "60", rec.Header().Get("X-RateLimit-Reset"))
+
+	rec = do(http.MethodPost, "/resource/", nil)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), ErrorCodeRateLimited)
+
+	// Made up api keys and user ids don't get a bucket of their own, the
+	// authenticated api key and the user id of a verified token do.
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/resource/", map[string]string{"X-User-Id": "u1"}).Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/resource/", map[string]string{"X-Api-Key": "k1"}).Code)
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "u1"}).SignedString([]byte("wrong"))
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/resource/", map[string]string{"X-Token": forged}).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/resource/", map[string]string{"X-Api-Key": "known"}).Code)
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "u1"}).SignedString([]byte("secret"))
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/resource/", map[string]string{"X-Token": token}).Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/resource/", map[string]string{"X-Token": token}).Code)
+
+	// Budgets without a limit are not counted.
+	rec = do(http.MethodGet, "/resource/"+manifestHash, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("X-RateLimit-Limit"))
+}
+
+func TestWeb_postResourceBatch_rateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := &Web{rl: &RateLimiter{
+		store: NewMemoryRateLimitStore(),
+		limits: map[RateBudget]RateLimit{
+			RateBudgetCreate: {Burst: 5, Period: time.Minute},
+		},
+		now: time.Now,
+	}}
+	r := gin.New()
+	r.Use(w.errorHandler)
+	r.POST("/resource/batch", w.postResourceBatch)
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/resource/batch", strings.NewReader(body))
+		req.RemoteAddr = "10.0.0.1:1234"
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Every resource of a batch counts.
+	rec := post(`["junk", "junk", "junk"]`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, http.StatusTooManyRequests, post(`["junk", "junk", "junk"]`).Code)
+	assert.Equal(t, http.StatusOK, post(`["junk", "junk"]`).Code)
+	assert.Equal(t, http.StatusTooManyRequests, post(`["junk", "junk", "junk", "junk", "junk", "junk"]`).Code)
+}
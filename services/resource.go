@@ -4,13 +4,12 @@ import (
 	"bytes"
 	"context"
 	"strings"
+	"sync"
 	"time"
 
 	gcodes "google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
-	"github.com/pkg/errors"
-
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/webtor-io/lazymap"
 
@@ -27,6 +26,34 @@ const (
 	ResourceTypeSha1
 )
 
+// ResourceStage is a step of resource resolution, reported to the progress
+// callback attached with WithResourceProgress.
+type ResourceStage string
+
+const (
+	ResourceStageQueued           ResourceStage = "queued"
+	ResourceStageFetchingMetadata ResourceStage = "fetching_metadata"
+	ResourceStagePushed           ResourceStage = "pushed"
+	ResourceStageReady            ResourceStage = "ready"
+	ResourceStageFailed           ResourceStage = "failed"
+)
+
+type resourceProgressKey struct{}
+
+// WithResourceProgress attaches f to ctx so that ResourceMap.get reports the
+// steps it walks through. Only the context of the call that actually runs
+// the resolution is observed: callers joining an in-flight lazymap entry see
+// no intermediate stages.
+func WithResourceProgress(ctx context.Context, f func(ResourceStage)) context.Context {
+	return context.WithValue(ctx, resourceProgressKey{}, f)
+}
+
+func reportResourceStage(ctx context.Context, st ResourceStage) {
+	if f, ok := ctx.Value(resourceProgressKey{}).(func(ResourceStage)); ok {
+		f(st)
+	}
+}
+
 type Resource struct {
 	ID        string
 	Name      string
@@ -35,6 +62,18 @@ type Resource struct {
 	Type      ResourceType
 	MagnetURI string
 	Torrent   []byte
+	indexOnce sync.Once
+	index     *DirIndex
+}
+
+// Index returns the directory index of the resource, built on first use.
+// Resources are immutable once loaded, so the index lives as long as the
+// resource stays in its lazymap.
+func (s *Resource) Index() *DirIndex {
+	s.indexOnce.Do(func() {
+		s.index = newDirIndex(s)
+	})
+	return s.index
 }
 
 type File struct {
@@ -84,7 +123,7 @@ func NewResourceMap(ts TorrentStoreGetter, m2t Magnet2TorrentGetter) *ResourceMa
 		Expire:      600 * time.Second,
 		Capacity:    1000,
 	})
-	return &ResourceMap{
+	rm := &ResourceMap{
 		LazyMap: lazymap.New[*Resource](&lazymap.Config{
 			Concurrency: 100,
 			Expire:      600 * time.Second,
@@ -96,6 +135,9 @@ func NewResourceMap(ts TorrentStoreGetter, m2t Magnet2TorrentGetter) *ResourceMa
 		torrentStoreTimeout: 10 * time.Second,
 		magnetTimeout:       3 * time.Minute,
 	}
+	lazyMapSizes.add("resources", rm.LazyMap.Len)
+	lazyMapSizes.add("manifests", rm.manifests.Len)
+	return rm
 }
 
 func (s *ResourceMap) parseMagnet(b []byte) (*Resource, error) {
@@ -159,13 +201,13 @@ func (s *ResourceMap) parse(b []byte) (*Resource, error) {
 	} else if strings.HasPrefix(string(b), "magnet:") {
 		r, err := s.parseMagnet(b)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to parse magnet")
+			return nil, wrapBadRequest(err, "failed to parse magnet")
 		}
 		return r, nil
 	} else {
 		r, err := s.parseTorrent(b)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to parse torrent")
+			return nil, wrapBadRequest(err, "failed to parse torrent")
 		}
 		return r, nil
 	}
@@ -174,7 +216,7 @@ func (s *ResourceMap) parse(b []byte) (*Resource, error) {
 func (s *ResourceMap) get(ctx context.Context, r *Resource, b []byte) (*Resource, error) {
 	ts, err := s.ts.Get()
 	if err != nil {
-		return nil, err
+		return nil, wrapUpstreamUnavailable(err, "failed to get torrent store client")
 	}
 	found := true
 	touchCtx, touchCancel := context.WithTimeout(ctx, s.torrentStoreTimeout)
@@ -184,26 +226,26 @@ func (s *ResourceMap) get(ctx context.Context, r *Resource, b []byte) (*Resource
 		if st, ok := status.FromError(err); ok {
 			switch st.Code() {
 			case gcodes.PermissionDenied:
-				return nil, errors.Wrap(err, "forbidden")
+				return nil, errForbidden(err)
 			case gcodes.NotFound:
 				found = false
 			default:
-				return nil, err
+				return nil, upstreamError(err)
 			}
 		} else {
-			return nil, err
+			return nil, upstreamError(err)
 		}
 	}
 	switch r.Type {
 	case ResourceTypeSha1:
 		if !found {
-			return nil, errors.Errorf("not found sha1=%v", r.ID)
+			return nil, errNotFound("not found sha1=%v", r.ID)
 		}
 		pullCtx, pullCancel := context.WithTimeout(ctx, s.torrentStoreTimeout)
 		defer pullCancel()
 		rep, err := ts.Pull(pullCtx, &tsp.PullRequest{InfoHash: r.ID})
 		if err != nil {
-			return nil, err
+			return nil, upstreamError(err)
 		}
 		return s.parseTorrent(rep.GetTorrent())
 
@@ -214,8 +256,9 @@ func (s *ResourceMap) get(ctx context.Context, r *Resource, b []byte) (*Resource
 		pushCtx, pushCancel := context.WithTimeout(ctx, s.torrentStoreTimeout)
 		defer pushCancel()
 		if _, err := ts.Push(pushCtx, &tsp.PushRequest{Torrent: b}); err != nil {
-			return nil, err
+			return nil, upstreamError(err)
 		}
+		reportResourceStage(ctx, ResourceStagePushed)
 		return r, nil
 
 	case ResourceTypeMagnet:
@@ -224,7 +267,7 @@ func (s *ResourceMap) get(ctx context.Context, r *Resource, b []byte) (*Resource
 			defer pullCancel()
 			rep, err := ts.Pull(pullCtx, &tsp.PullRequest{InfoHash: r.ID})
 			if err != nil {
-				return nil, err
+				return nil, upstreamError(err)
 			}
 			// Even when the torrent is already cached, push any tr= trackers
 			// from the incoming magnet so torrent-store can merge them in.
@@ -237,15 +280,16 @@ func (s *ResourceMap) get(ctx context.Context, r *Resource, b []byte) (*Resource
 		}
 		m2t, err := s.m2t.Get()
 		if err != nil {
-			return nil, err
+			return nil, wrapUpstreamUnavailable(err, "failed to get magnet2torrent client")
 		}
+		reportResourceStage(ctx, ResourceStageFetchingMetadata)
 		magnetCtx, magnetCancel := context.WithTimeout(ctx, s.magnetTimeout)
 		defer magnetCancel()
 		rep, err := m2t.Magnet2Torrent(magnetCtx, &m2tp.Magnet2TorrentRequest{Magnet: string(b)})
 		if err != nil && magnetCtx.Err() != nil {
-			return nil, errors.Wrap(err, "magnet timeout")
+			return nil, wrapUpstreamTimeout(err, "magnet timeout")
 		} else if err != nil {
-			return nil, err
+			return nil, upstreamError(err)
 		}
 		// Inject the magnet's tr= trackers before pushing — m2t strips them
 		// during DHT metadata exchange, leaving the .torrent trackerless.
@@ -255,8 +299,9 @@ func (s *ResourceMap) get(ctx context.Context, r *Resource, b []byte) (*Resource
 		}
 		_, err = ts.Push(ctx, &tsp.PushRequest{Torrent: payload})
 		if err != nil {
-			return nil, err
+			return nil, upstreamError(err)
 		}
+		reportResourceStage(ctx, ResourceStagePushed)
 		return s.parseTorrent(payload)
 	}
 	return nil, nil
@@ -294,7 +339,9 @@ func (s *ResourceMap) Get(ctx context.Context, b []byte) (*Resource, error) {
 		return nil, err
 	}
 	return s.LazyMap.Get(r.ID, func() (*Resource, error) {
-		return s.get(ctx, r, b)
+		res, err := s.get(ctx, r, b)
+		observeResourceError("get", err)
+		return res, err
 	})
 }
 
@@ -307,14 +354,16 @@ func (s *ResourceMap) Get(ctx context.Context, b []byte) (*Resource, error) {
 // store-and-resolve POST path, which uses Get).
 func (s *ResourceMap) GetManifest(ctx context.Context, infohash string) (*Resource, error) {
 	return s.manifests.Get(infohash, func() (*Resource, error) {
-		return s.getManifest(ctx, infohash)
+		res, err := s.getManifest(ctx, infohash)
+		observeResourceError("manifest", err)
+		return res, err
 	})
 }
 
 func (s *ResourceMap) getManifest(ctx context.Context, infohash string) (*Resource, error) {
 	ts, err := s.ts.Get()
 	if err != nil {
-		return nil, err
+		return nil, wrapUpstreamUnavailable(err, "failed to get torrent store client")
 	}
 	fctx, cancel := context.WithTimeout(ctx, s.torrentStoreTimeout)
 	defer cancel()
@@ -328,13 +377,12 @@ func (s *ResourceMap) getManifest(ctx context.Context, infohash string) (*Resour
 				// the store's rollout/rollback.
 				return s.Get(ctx, []byte(infohash))
 			case gcodes.PermissionDenied:
-				// "forbidden" so the web error handler maps to 403, matching get().
-				return nil, errors.Wrap(err, "forbidden")
+				return nil, errForbidden(err)
 			case gcodes.NotFound:
-				return nil, errors.Errorf("not found infoHash=%v", infohash)
+				return nil, errNotFound("not found infoHash=%v", infohash)
 			}
 		}
-		return nil, err
+		return nil, upstreamError(err)
 	}
 	r := &Resource{
 		ID:   infohash,
@@ -347,5 +395,7 @@ func (s *ResourceMap) getManifest(ctx context.Context, infohash string) (*Resour
 			Size: f.GetLength(),
 		})
 	}
+	// Built here, once per manifest load, instead of by the first listing.
+	r.Index()
 	return r, nil
 }
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type ResourceJobStatus string
+
+const (
+	ResourceJobStatusPending  ResourceJobStatus = "pending"
+	ResourceJobStatusResolved ResourceJobStatus = "resolved"
+	ResourceJobStatusFailed   ResourceJobStatus = "failed"
+)
+
+// ResourceJob is a resource resolution running detached from the HTTP
+// request that started it. Its ID is the resource infohash, so every
+// submission of the same torrent (magnet, .torrent or bare infohash) lands
+// on the same job.
+type ResourceJob struct {
+	ID      string
+	mux     sync.RWMutex
+	status  ResourceJobStatus
+	stages  []ResourceStage
+	changed chan struct{}
+	r       *Resource
+	err     error
+	done    chan struct{}
+	// finishedAt is when the job got resolved or failed, zero while it is
+	// pending.
+	finishedAt time.Time
+}
+
+func newResourceJob(id string) *ResourceJob {
+	return &ResourceJob{
+		ID:      id,
+		status:  ResourceJobStatusPending,
+		stages:  []ResourceStage{ResourceStageQueued},
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Stages returns the stages the job went through starting from index from,
+// and a channel that is closed once the next stage is reached. Keeping the
+// whole history lets a subscriber see stages that followed each other too
+// quickly to be observed one by one.
+func (s *ResourceJob) Stages(from int) ([]ResourceStage, <-chan struct{}) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if from >= len(s.stages) {
+		return nil, s.changed
+	}
+	return append([]ResourceStage(nil), s.stages[from:]...), s.changed
+}
+
+func (s *ResourceJob) setStage(st ResourceStage) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.setStageLocked(st)
+}
+
+func (s *ResourceJob) setStageLocked(st ResourceStage) {
+	if s.stages[len(s.stages)-1] == st {
+		return
+	}
+	s.stages = append(s.stages, st)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// State returns the job status together with the resolved resource or the
+// resolution error, whichever applies.
+func (s *ResourceJob) State() (ResourceJobStatus, *Resource, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.status, s.r, s.err
+}
+
+// Done is closed once the job is resolved or failed.
+func (s *ResourceJob) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *ResourceJob) finish(r *Resource, err error) {
+	s.mux.Lock()
+	s.finishedAt = time.Now()
+	if err != nil {
+		s.status = ResourceJobStatusFailed
+		s.err = err
+		s.setStageLocked(ResourceStageFailed)
+	} else {
+		s.status = ResourceJobStatusResolved
+		s.r = r
+		s.setStageLocked(ResourceStageReady)
+	}
+	s.mux.Unlock()
+	close(s.done)
+}
+
+func (s *ResourceJob) finished() time.Time {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.finishedAt
+}
+
+// ResourceJobs keeps the started jobs. Unlike a lazymap, it never evicts a
+// pending job: only finished jobs expire, or make room once there are more
+// than capacity of them, so a running job can always be polled.
+type ResourceJobs struct {
+	mux      sync.Mutex
+	jobs     map[string]*ResourceJob
+	rm       *ResourceMap
+	timeout  time.Duration
+	expire   time.Duration
+	capacity int
+	// maxPending caps the jobs resolving at once, each holds a goroutine
+	// and a magnet2torrent call.
+	maxPending int
+	pending    int
+}
+
+func NewResourceJobs(rm *ResourceMap) *ResourceJobs {
+	rj := &ResourceJobs{
+		jobs: map[string]*ResourceJob{},
+		rm:   rm,
+		// Upper bound for the whole Touch → Magnet2Torrent → Push chain; the
+		// job runs on a background context, so nothing else cancels it.
+		timeout:    5 * time.Minute,
+		expire:     600 * time.Second,
+		capacity:   1000,
+		maxPending: 100,
+	}
+	lazyMapSizes.add("resource_jobs", rj.Len)
+	return rj
+}
+
+// Len returns the number of jobs kept.
+func (s *ResourceJobs) Len() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.jobs)
+}
+
+// Start submits b (magnet-uri, infohash or .torrent) for resolution and
+// returns immediately. Payload errors are reported synchronously; a pending
+// or resolved job for the same infohash is reused, a failed one is retried.
+// With too many jobs pending already, a new one is refused.
+func (s *ResourceJobs) Start(b []byte) (*ResourceJob, error) {
+	r, err := s.rm.parse(b)
+	if err != nil {
+		return nil, err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.clean(time.Now())
+	if j, ok := s.jobs[r.ID]; ok {
+		if st, _, _ := j.State(); st != ResourceJobStatusFailed {
+			return j, nil
+		}
+	}
+	if s.pending >= s.maxPending {
+		return nil, errRateLimited("too many resources resolving, %d pending", s.pending)
+	}
+	j := newResourceJob(r.ID)
+	s.jobs[r.ID] = j
+	s.pending++
+	go s.run(j, b)
+	return j, nil
+}
+
+// clean drops the expired finished jobs and, above capacity, the finished
+// jobs that are the longest done. Must be called with s.mux held.
+func (s *ResourceJobs) clean(now time.Time) {
+	var done []*ResourceJob
+	for id, j := range s.jobs {
+		f := j.finished()
+		if f.IsZero() {
+			continue
+		}
+		if now.Sub(f) >= s.expire {
+			delete(s.jobs, id)
+			continue
+		}
+		done = append(done, j)
+	}
+	if len(s.jobs) <= s.capacity {
+		return
+	}
+	sort.Slice(done, func(i, k int) bool {
+		return done[i].finished().Before(done[k].finished())
+	})
+	for _, j := range done {
+		if len(s.jobs) <= s.capacity {
+			break
+		}
+		delete(s.jobs, j.ID)
+	}
+}
+
+func (s *ResourceJobs) run(j *ResourceJob, b []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	ctx = WithResourceProgress(ctx, j.setStage)
+	// ResourceMap.Get is backed by lazymap too, so a synchronous POST for
+	// the same infohash running in parallel shares this resolution.
+	r, err := s.rm.Get(ctx, b)
+	j.finish(r, err)
+	s.mux.Lock()
+	s.pending--
+	s.mux.Unlock()
+}
+
+// Get returns a previously started job.
+func (s *ResourceJobs) Get(id string) (*ResourceJob, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, errNotFound("job not found id=%v", id)
+	}
+	if f := j.finished(); !f.IsZero() && time.Since(f) >= s.expire {
+		delete(s.jobs, id)
+		return nil, errNotFound("job not found id=%v", id)
+	}
+	return j, nil
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	m2tp "github.com/webtor-io/magnet2torrent/magnet2torrent"
+)
+
+func waitResourceJob(t *testing.T, j *ResourceJob) {
+	select {
+	case <-j.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("job %v did not finish", j.ID)
+	}
+}
+
+// Concurrent submissions of the same torrent must collapse into one job and
+// one magnet2torrent fetch, and the job must be reachable by its infohash.
+func TestResourceJobs_StartDedup(t *testing.T) {
+	assert := assert.New(t)
+	rm := NewTestResourceMap()
+	tsclm, _ := rm.ts.Get()
+	tsclmm := tsclm.(*TorrentStoreClientMock)
+	m2tclm, _ := rm.m2t.Get()
+	m2tclmm := m2tclm.(*Magnet2TorrentClientMock)
+	tsclmm.On("Touch", mock.Anything, mock.Anything, mock.Anything).Return(nil, status.Error(codes.NotFound, "not found"))
+	tsclmm.On("Push", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	m2tclmm.On("Magnet2Torrent", mock.Anything, mock.Anything, mock.Anything).Return(&m2tp.Magnet2TorrentReply{
+		Torrent: loadSintel(t),
+	}, nil).Once()
+	m2tclmm.sleep = 50 * time.Millisecond
+
+	rj := NewResourceJobs(rm)
+	j1, err := rj.Start([]byte(sintelMagnet))
+	assert.Nil(err)
+	j2, err := rj.Start([]byte(manifestHash))
+	assert.Nil(err)
+	assert.Same(j1, j2)
+	assert.Equal(manifestHash, j1.ID)
+
+	st, _, _ := j1.State()
+	assert.Equal(ResourceJobStatusPending, st)
+
+	waitResourceJob(t, j1)
+	j3, err := rj.Get(manifestHash)
+	assert.Nil(err)
+	st, r, err := j3.State()
+	assert.Equal(ResourceJobStatusResolved, st)
+	assert.Nil(err)
+	if assert.NotNil(r) {
+		assert.Equal(manifestHash, r.ID)
+	}
+	m2tclmm.AssertExpectations(t)
+}
+
+func TestResourceJobs_FailedJobIsRetried(t *testing.T) {
+	assert := assert.New(t)
+	rm := NewTestResourceMap()
+	tsclm, _ := rm.ts.Get()
+	tsclmm := tsclm.(*TorrentStoreClientMock)
+	tsclmm.On("Touch", mock.Anything, mock.Anything, mock.Anything).Return(nil, status.Error(codes.NotFound, "not found"))
+
+	rj := NewResourceJobs(rm)
+	j1, err := rj.Start([]byte(manifestHash))
+	assert.Nil(err)
+	waitResourceJob(t, j1)
+	st, _, err := j1.State()
+	assert.Equal(ResourceJobStatusFailed, st)
+	assert.ErrorContains(err, "not found")
+
+	j2, err := rj.Start([]byte(manifestHash))
+	assert.Nil(err)
+	assert.NotSame(j1, j2)
+	waitResourceJob(t, j2)
+}
+
+func TestResourceJobs_GetUnknown(t *testing.T) {
+	rm := NewTestResourceMap()
+	tsclm, _ := rm.ts.Get()
+	tsclmm := tsclm.(*TorrentStoreClientMock)
+	tsclmm.On("Touch", mock.Anything, mock.Anything, mock.Anything).Return(nil, status.Error(codes.NotFound, "not found"))
+
+	rj := NewResourceJobs(rm)
+	_, err := rj.Get(manifestHash)
+	assert.ErrorContains(t, err, "not found")
+
+	// Polling an unknown job leaves nothing behind for Start to trip on.
+	j, err := rj.Start([]byte(manifestHash))
+	if assert.Nil(t, err) && assert.NotNil(t, j) {
+		waitResourceJob(t, j)
+	}
+}
+
+func TestResourceJobs_maxPending(t *testing.T) {
+	assert := assert.New(t)
+	rm := NewTestResourceMap()
+	tsclm, _ := rm.ts.Get()
+	tsclmm := tsclm.(*TorrentStoreClientMock)
+	m2tclm, _ := rm.m2t.Get()
+	m2tclmm := m2tclm.(*Magnet2TorrentClientMock)
+	tsclmm.On("Touch", mock.Anything, mock.Anything, mock.Anything).Return(nil, status.Error(codes.NotFound, "not found"))
+	tsclmm.On("Push", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	m2tclmm.On("Magnet2Torrent", mock.Anything, mock.Anything, mock.Anything).Return(&m2tp.Magnet2TorrentReply{
+		Torrent: loadSintel(t),
+	}, nil)
+	m2tclmm.sleep = 50 * time.Millisecond
+
+	rj := NewResourceJobs(rm)
+	rj.maxPending = 1
+	j1, err := rj.Start([]byte(sintelMagnet))
+	assert.Nil(err)
+	// The pending job is still reused, another one is refused.
+	j2, err := rj.Start([]byte(manifestHash))
+	assert.Nil(err)
+	assert.Same(j1, j2)
+	_, err = rj.Start([]byte("da39a3ee5e6b4b0d3255bfef95601890afd80709"))
+	assert.Equal(ErrorCodeRateLimited, ErrorCodeOf(err))
+
+	waitResourceJob(t, j1)
+	assert.Eventually(func() bool {
+		_, err := rj.Start([]byte("da39a3ee5e6b4b0d3255bfef95601890afd80709"))
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+// Pending jobs are never evicted, finished ones make room above capacity.
+func TestResourceJobs_clean(t *testing.T) {
+	rj := NewResourceJobs(NewTestResourceMap())
+	rj.capacity = 1
+	pending := newResourceJob("pending")
+	old := newResourceJob("old")
+	old.finish(nil, nil)
+	recent := newResourceJob("recent")
+	recent.finish(nil, nil)
+	rj.jobs = map[string]*ResourceJob{"pending": pending, "old": old, "recent": recent}
+
+	rj.clean(time.Now())
+	assert.Len(t, rj.jobs, 1)
+	assert.Contains(t, rj.jobs, "pending")
+
+	rj.jobs["recent"] = recent
+	rj.clean(time.Now().Add(rj.expire))
+	_, err := rj.Get("recent")
+	assert.ErrorContains(t, err, "not found")
+	_, err = rj.Get("pending")
+	assert.Nil(t, err)
+}
+
+func TestResourceMap_reportsStages(t *testing.T) {
+	assert := assert.New(t)
+	rm := NewTestResourceMap()
+	tsclm, _ := rm.ts.Get()
+	tsclmm := tsclm.(*TorrentStoreClientMock)
+	m2tclm, _ := rm.m2t.Get()
+	m2tclmm := m2tclm.(*Magnet2TorrentClientMock)
+	tsclmm.On("Touch", mock.Anything, mock.Anything, mock.Anything).Return(nil, status.Error(codes.NotFound, "not found"))
+	tsclmm.On("Push", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	m2tclmm.On("Magnet2Torrent", mock.Anything, mock.Anything, mock.Anything).Return(&m2tp.Magnet2TorrentReply{
+		Torrent: loadSintel(t),
+	}, nil)
+
+	var stages []ResourceStage
+	ctx := WithResourceProgress(context.Background(), func(st ResourceStage) {
+		stages = append(stages, st)
+	})
+	_, err := rm.Get(ctx, []byte(sintelMagnet))
+	assert.Nil(err)
+	assert.Equal([]ResourceStage{ResourceStageFetchingMetadata, ResourceStagePushed}, stages)
+}
+
+func TestWeb_getResolveEvents(t *testing.T) {
+	assert := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	rm := NewTestResourceMap()
+	tsclm, _ := rm.ts.Get()
+	tsclmm := tsclm.(*TorrentStoreClientMock)
+	m2tclm, _ := rm.m2t.Get()
+	m2tclmm := m2tclm.(*Magnet2TorrentClientMock)
+	tsclmm.On("Touch", mock.Anything, mock.Anything, mock.Anything).Return(nil, status.Error(codes.NotFound, "not found"))
+	tsclmm.On("Push", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	m2tclmm.On("Magnet2Torrent", mock.Anything, mock.Anything, mock.Anything).Return(&m2tp.Magnet2TorrentReply{
+		Torrent: loadSintel(t),
+	}, nil)
+	m2tclmm.sleep = 50 * time.Millisecond
+
+	w := &Web{rm: rm, rj: NewResourceJobs(rm), c: NewList()}
+	_, err := w.rj.Start([]byte(sintelMagnet))
+	assert.Nil(err)
+
+	r := gin.New()
+	r.GET("/resource/:resource_id/resolve/events", w.getResolveEvents)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	res, err := srv.Client().Get(srv.URL + "/resource/" + manifestHash + "/resolve/events")
+	if !assert.Nil(err) {
+		return
+	}
+	defer res.Body.Close()
+	assert.True(strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream"))
+	body, err := io.ReadAll(res.Body)
+	assert.Nil(err)
+	events := string(body)
+	assert.Contains(events, "event:queued")
+	assert.Contains(events, "event:fetching_metadata")
+	assert.Contains(events, "event:pushed")
+	assert.True(strings.HasPrefix(events[strings.LastIndex(events, "event:"):], "event:ready"))
+	assert.Contains(events, `"id":"`+manifestHash+`"`)
+	assert.Less(strings.Index(events, "event:pushed"), strings.Index(events, "event:ready"))
+}
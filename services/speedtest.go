@@ -1,12 +1,11 @@
 package services
 
 import (
-	"fmt"
+	"context"
 	"math/rand"
 	"net/url"
 	"strconv"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli"
 )
@@ -19,8 +18,8 @@ type SpeedTest struct {
 	nsp               *NodesStat
 	domain            string
 	premiumDomain     string
+	tk                *Tokens
 	apiKey            string
-	apiSecret         string
 	apiRole           string
 	useSubdomains     bool
 	subdomainsK8SPool string
@@ -35,7 +34,7 @@ type SpeedtestResponse struct {
 	URLs []SpeedtestURL `json:"urls"`
 }
 
-func NewSpeedTest(c *cli.Context, nsp *NodesStat) *SpeedTest {
+func NewSpeedTest(c *cli.Context, nsp *NodesStat, tk *Tokens) *SpeedTest {
 	domain := c.String(exportDomainFlag)
 	if domain == "" {
 		return nil
@@ -44,16 +43,16 @@ func NewSpeedTest(c *cli.Context, nsp *NodesStat) *SpeedTest {
 		nsp:               nsp,
 		domain:            domain,
 		premiumDomain:     c.String(exportPremiumDomainFlag),
+		tk:                tk,
 		apiKey:            c.String(exportApiKeyFlag),
-		apiSecret:         c.String(exportApiSecretFlag),
 		apiRole:           c.String(exportApiRoleFlag),
 		useSubdomains:     c.BoolT(exportUseSubdomainsFlag),
 		subdomainsK8SPool: c.String(exportSubdomainsK8SPoolFlag),
 	}
 }
 
-func (s *SpeedTest) getRandomSubdomain(role string) (string, error) {
-	stats, err := s.nsp.Get()
+func (s *SpeedTest) getRandomSubdomain(ctx context.Context, role string) (string, error) {
+	stats, err := s.nsp.Get(ctx)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to get nodes stat")
 	}
@@ -85,24 +84,6 @@ func (s *SpeedTest) getRandomSubdomain(role string) (string, error) {
 	return candidates[rand.Intn(len(candidates))].Subdomain, nil
 }
 
-func (s *SpeedTest) makeToken(g ParamGetter) (string, error) {
-	if t := g.Query("token"); t != "" {
-		return t, nil
-	}
-	if t := g.GetHeader("X-Token"); t != "" {
-		return t, nil
-	}
-	if s.apiSecret != "" {
-		claims := jwt.MapClaims{}
-		if s.apiRole != "" {
-			claims["role"] = s.apiRole
-		}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		return token.SignedString([]byte(s.apiSecret))
-	}
-	return "", nil
-}
-
 func (s *SpeedTest) getApiKey(g ParamGetter) string {
 	if k := g.Query("api-key"); k != "" {
 		return k
@@ -113,27 +94,15 @@ func (s *SpeedTest) getApiKey(g ParamGetter) string {
 	return s.apiKey
 }
 
-func (s *SpeedTest) getRole(g ParamGetter) string {
-	tokenStr := g.Query("token")
-	if tokenStr == "" {
-		tokenStr = g.GetHeader("X-Token")
+func (s *SpeedTest) getRole(g ParamGetter) (string, error) {
+	r, err := s.tk.Role(g)
+	if err != nil {
+		return "", err
 	}
-	if tokenStr != "" && s.apiSecret != "" {
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method=%v", token.Header["alg"])
-			}
-			return []byte(s.apiSecret), nil
-		})
-		if err == nil {
-			if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-				if r, ok := claims["role"].(string); ok {
-					return r
-				}
-			}
-		}
+	if r == "" {
+		return s.apiRole, nil
 	}
-	return s.apiRole
+	return r, nil
 }
 
 func (s *SpeedTest) buildURL(domainStr string, g ParamGetter) (string, error) {
@@ -142,11 +111,14 @@ func (s *SpeedTest) buildURL(domainStr string, g ParamGetter) (string, error) {
 		return "", errors.Wrap(err, "failed to parse domain")
 	}
 
-	role := s.getRole(g)
+	role, err := s.getRole(g)
+	if err != nil {
+		return "", err
+	}
 	domain := du.Host
 
 	if s.useSubdomains {
-		sub, err := s.getRandomSubdomain(role)
+		sub, err := s.getRandomSubdomain(requestContext(g), role)
 		if err != nil {
 			return "", errors.Wrap(err, "failed to get random subdomain")
 		}
@@ -155,7 +127,7 @@ func (s *SpeedTest) buildURL(domainStr string, g ParamGetter) (string, error) {
 		}
 	}
 
-	token, err := s.makeToken(g)
+	token, _, err := s.tk.Mint(g, nil, nil)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to make token")
 	}
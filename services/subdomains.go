@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"math"
 	"sort"
 	"strconv"
@@ -113,8 +114,8 @@ func (s *Subdomains) updateScoreByInfoHash(stats []NodeStatWithScore, infohash s
 	return stats, nil
 }
 
-func (s *Subdomains) getScoredStats(infohash string, pool string, role string) ([]NodeStatWithScore, error) {
-	stats, err := s.nsp.Get()
+func (s *Subdomains) getScoredStats(ctx context.Context, infohash string, pool string, role string) ([]NodeStatWithScore, error) {
+	stats, err := s.nsp.Get(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get nodes stat")
 	}
@@ -164,8 +165,8 @@ func (s *Subdomains) getScoredStatsByPoolAndRole(sc []NodeStatWithScore, infohas
 	return sc, nil
 }
 
-func (s *Subdomains) Get(infohash string, pool string, role string) ([]string, error) {
-	stats, err := s.getScoredStats(infohash, pool, role)
+func (s *Subdomains) Get(ctx context.Context, infohash string, pool string, role string) ([]string, error) {
+	stats, err := s.getScoredStats(ctx, infohash, pool, role)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get sorted nodes stat")
 	}
@@ -1,12 +1,11 @@
 package services
 
 import (
-	"mime"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"golang.org/x/text/language"
-	"golang.org/x/text/language/display"
 )
 
 type TagBuilder struct {
@@ -74,24 +73,38 @@ func (s *BaseTagBuilder) BuildURL(i *ListItem) (*MyURL, error) {
 }
 
 func (s *BaseTagBuilder) BuildSource(u *MyURL) *ExportSource {
-	ext := filepath.Ext(u.Path)
 	t := ""
-	if strings.HasSuffix(u.Path, "index.m3u8") {
-		t = "application/vnd.apple.mpegurl"
+	if u.streamFormat != "" {
+		t = u.streamFormat.MimeType()
 	} else {
-		t = mime.TypeByExtension(ext)
+		t = getMimeTypeByExt(fileExt(u.Path))
 	}
 	return &ExportSource{
 		Src:  u.String(),
 		Type: t,
 	}
 }
+
+// BuildAVTag builds the tag with a source in every stream format when the
+// content is streamed by manifest, the requested stream-format first.
 func (s *BaseTagBuilder) BuildAVTag(n ExportTagName) (*ExportTag, error) {
 	url, err := s.BuildURL(s.i)
 	if err != nil {
 		return nil, err
 	}
-	src := s.BuildSource(url)
+	sources := []ExportSource{*s.BuildSource(url)}
+	if url.streamFormat != "" {
+		for _, f := range StreamFormats {
+			if f == url.streamFormat {
+				continue
+			}
+			u, err := s.ub.BuildStream(s.r, s.i, s.g, f)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, *s.BuildSource(u))
+		}
+	}
 	preload := ExportPreloadTypeNone
 	if url.cached {
 		preload = ExportPreloadTypeAuto
@@ -99,9 +112,7 @@ func (s *BaseTagBuilder) BuildAVTag(n ExportTagName) (*ExportTag, error) {
 	return &ExportTag{
 		Name:    n,
 		Preload: preload,
-		Sources: []ExportSource{
-			*src,
-		},
+		Sources: sources,
 	}, nil
 }
 
@@ -119,53 +130,255 @@ func (s *ListItem) SameDirectory(li *ListItem) bool {
 	return true
 }
 
+// subtitleDirs are the subfolders, lowercased, scene releases keep the
+// subtitles of a video directory in.
+var subtitleDirs = []string{"subs", "subtitles"}
+
+// posterNames are the image names, lowercased and without extension,
+// preferred as video poster, best first. An image named after the video
+// beats them all.
+var posterNames = []string{"poster", "cover", "folder"}
+
+// videoBase returns the video file name without extension.
+func (s *VideoTagBuider) videoBase() string {
+	return strings.TrimSuffix(s.i.Name, filepath.Ext(s.i.Name))
+}
+
+// BuildTrack builds the track of a subtitle file. Language and flags come
+// from the file name left after the video name, like in Movie.en.forced.srt,
+// 2_English.srt, Movie [Spanish].srt or eng.sdh.srt, or else from the
+// folders between the video and the file, like Subs/Russian/. The last
+// language of a name wins, release names put the language at the end.
 func (s *VideoTagBuider) BuildTrack(i *ListItem) (*ExportTrack, error) {
 	u, err := s.BuildURL(i)
 	if err != nil {
 		return nil, err
 	}
-	lc := filepath.Ext(strings.TrimSuffix(i.Name, filepath.Ext(i.Name)))
-	label := ""
-	srclang := ""
-	if lc != "" {
-		lc = strings.TrimLeft(lc, ".")
-		t, err := language.Parse(lc)
-		if err == nil {
-			srclang = t.String()
-			label = display.English.Tags().Name(t)
-		}
-	}
-	return &ExportTrack{
-		Kind:    ExportKindTypeSubtitles,
-		Src:     u.String(),
-		Label:   label,
-		SrcLang: srclang,
-	}, nil
+	et := &ExportTrack{
+		Kind: ExportKindTypeSubtitles,
+		Src:  u.String(),
+	}
+	name := strings.TrimSuffix(i.Name, filepath.Ext(i.Name))
+	name = strings.TrimPrefix(name, s.videoBase())
+	var words []string
+	for _, tok := range nameTokens(name) {
+		switch strings.ToLower(tok) {
+		case "forced":
+			et.Forced = true
+		case "sdh", "cc":
+			et.SDH = true
+		default:
+			words = append(words, tok)
+		}
+	}
+	langs := nameLanguages(words)
+	// hi is Hindi as well, it marks SDH only after another language.
+	if n := len(langs); n > 1 && langs[n-1].token == "hi" {
+		et.SDH = true
+		langs = langs[:n-1]
+	}
+	if len(langs) == 0 {
+		langs = s.folderLanguages(i)
+	}
+	if len(langs) > 0 {
+		t := langs[len(langs)-1].tag
+		et.SrcLang = t.String()
+		et.Label = languageLabel(t)
+	}
+	var flags []string
+	if et.Forced {
+		flags = append(flags, "Forced")
+	}
+	if et.SDH {
+		et.Kind = ExportKindTypeCaptions
+		flags = append(flags, "SDH")
+	}
+	if len(flags) > 0 {
+		f := strings.Join(flags, ", ")
+		if et.Label == "" {
+			et.Label = f
+		} else {
+			et.Label += " (" + f + ")"
+		}
+	}
+	return et, nil
 }
 
-func (s *VideoTagBuider) BuildAttachedResources(et *ExportTag) (*ExportTag, error) {
+// folderLanguages returns the languages named by the nearest folder between
+// the video and the subtitle file that names any.
+func (s *VideoTagBuider) folderLanguages(i *ListItem) []languageToken {
+	dirs := i.Path[len(s.i.Path)-1 : len(i.Path)-1]
+	for n := len(dirs) - 1; n >= 0; n-- {
+		d := dirs[n]
+		if slices.Contains(subtitleDirs, strings.ToLower(d)) || strings.EqualFold(d, s.videoBase()) {
+			continue
+		}
+		if langs := languageFolder(d); len(langs) > 0 {
+			return langs
+		}
+	}
+	return nil
+}
+
+// markDefault marks the track in the language the client prefers as
+// default, full subtitles over SDH and forced ones.
+func (s *VideoTagBuider) markDefault(tt []ExportTrack) {
+	prefs := preferredLanguages(s.g)
+	if len(prefs) == 0 {
+		return
+	}
+	var tags []language.Tag
+	var tracks []int
+	for n, t := range tt {
+		if tag, err := language.Parse(t.SrcLang); err == nil && t.SrcLang != "" {
+			tags = append(tags, tag)
+			tracks = append(tracks, n)
+		}
+	}
+	if len(tags) == 0 {
+		return
+	}
+	_, m, c := language.NewMatcher(tags).Match(prefs...)
+	if c == language.No {
+		return
+	}
+	lang := tt[tracks[m]].SrcLang
+	rank := func(t *ExportTrack) int {
+		switch {
+		case t.Forced:
+			return 2
+		case t.SDH:
+			return 1
+		}
+		return 0
+	}
+	best := -1
+	for n := range tt {
+		if tt[n].SrcLang == lang && (best < 0 || rank(&tt[n]) < rank(&tt[best])) {
+			best = n
+		}
+	}
+	tt[best].Default = true
+}
+
+// isTrack reports whether the subtitle can be attached as a WebVTT track.
+func (s *VideoTagBuider) isTrack(i *ListItem) bool {
+	return i.MediaFormat == Subtitle && (i.Ext == "vtt" || canConvertToVTT(s.r, i))
+}
+
+// posterRank ranks an image as poster candidate, lower is better.
+func (s *VideoTagBuider) posterRank(i *ListItem) int {
+	n := strings.ToLower(strings.TrimSuffix(i.Name, filepath.Ext(i.Name)))
+	if n == strings.ToLower(s.videoBase()) {
+		return 0
+	}
+	if r := slices.Index(posterNames, n); r >= 0 {
+		return r + 1
+	}
+	return len(posterNames) + 1
+}
+
+func (s *VideoTagBuider) list(path []string) ([]ListItem, error) {
 	r, err := s.l.Get(s.r, &ListGetArgs{
-		Path: s.i.Path[0 : len(s.i.Path)-1],
+		Path: path,
 	})
 	if err != nil {
 		return nil, err
 	}
-	var tt []ExportTrack
-	for _, v := range r.Items {
-		if v.SameDirectory(s.i) && v.MediaFormat == Subtitle && strings.HasPrefix(v.Name, strings.TrimSuffix(s.i.Name, "."+s.i.Ext)) {
-			t, err := s.BuildTrack(&v)
-			if err != nil {
-				return nil, err
+	return r.Items, nil
+}
+
+// findSubtitles returns the subtitles of the video: the ones next to it
+// named after it, and the ones in Subs/ or Subtitles/ subfolders, where
+// they are matched by name unless the video is the only one of the
+// directory, or are kept in a Subs/<video name>/ folder. Subs/<language>/
+// folders are matched like Subs/ itself.
+func (s *VideoTagBuider) findSubtitles(items []ListItem) ([]ListItem, error) {
+	base := s.videoBase()
+	var res []ListItem
+	var dirs []ListItem
+	videos := 0
+	for _, v := range items {
+		switch {
+		case v.MediaFormat == Video:
+			videos++
+		case v.Type == ListTypeDirectory && slices.Contains(subtitleDirs, strings.ToLower(v.Name)):
+			dirs = append(dirs, v)
+		case s.isTrack(&v) && strings.HasPrefix(v.Name, base):
+			res = append(res, v)
+		}
+	}
+	// Language folders found inside are appended to dirs while ranging.
+	for n := 0; n < len(dirs); n++ {
+		sub, err := s.list(dirs[n].Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range sub {
+			if v.Type == ListTypeDirectory && strings.EqualFold(v.Name, base) {
+				own, err := s.list(v.Path)
+				if err != nil {
+					return nil, err
+				}
+				for _, o := range own {
+					if s.isTrack(&o) {
+						res = append(res, o)
+					}
+				}
+				continue
 			}
-			if t != nil {
-				tt = append(tt, *t)
+			if v.Type == ListTypeDirectory && len(languageFolder(v.Name)) > 0 {
+				dirs = append(dirs, v)
+				continue
 			}
-		}
-		if v.SameDirectory(s.i) && v.MediaFormat == Image && et.Poster == "" {
-			u, err := s.BuildURL(&v)
-			if err != nil {
-				return nil, err
+			if s.isTrack(&v) && (videos == 1 || strings.HasPrefix(v.Name, base)) {
+				res = append(res, v)
 			}
+		}
+	}
+	return res, nil
+}
+
+func (s *VideoTagBuider) BuildAttachedResources(et *ExportTag) (*ExportTag, error) {
+	items, err := s.list(s.i.Path[0 : len(s.i.Path)-1])
+	if err != nil {
+		return nil, err
+	}
+	subs, err := s.findSubtitles(items)
+	if err != nil {
+		return nil, err
+	}
+	var tt []ExportTrack
+	for _, v := range subs {
+		t, err := s.BuildTrack(&v)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			tt = append(tt, *t)
+		}
+	}
+	s.markDefault(tt)
+	var poster *ListItem
+	for n, v := range items {
+		if v.MediaFormat == Image && (poster == nil || s.posterRank(&v) < s.posterRank(poster)) {
+			poster = &items[n]
+		}
+	}
+	if poster != nil && et.Poster == "" {
+		u, err := s.BuildURL(poster)
+		if err != nil {
+			return nil, err
+		}
+		et.Poster = u.String()
+	}
+	if et.Poster == "" {
+		// No image to show, fall back to a frame of the video itself.
+		u, err := s.ub.Build(s.r, s.i, s.g, ExportTypePreview)
+		if err != nil {
+			return nil, err
+		}
+		if u != nil {
 			et.Poster = u.String()
 		}
 	}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTagBuilder(t *testing.T) (*TagBuilder, *Resource) {
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(probe.Close)
+	ub := &URLBuilder{
+		cm:         newTestCacheMap(probe.Client(), time.Second),
+		domain:     probe.URL,
+		pathPrefix: "/",
+		tk:         testTokens("", 0),
+	}
+	r := &Resource{
+		ID:   manifestHash,
+		Name: "Movie",
+		Files: []*File{
+			{Path: []string{"Movie", "movie.mp4"}, Size: 1},
+			{Path: []string{"Movie", "movie.mkv"}, Size: 1},
+			{Path: []string{"Movie", "track.mp3"}, Size: 1},
+		},
+	}
+	return NewTagBuilder(ub, NewList()), r
+}
+
+func TestTagBuilder_streamFormats(t *testing.T) {
+	tb, r := testTagBuilder(t)
+	types := func(et *ExportTag) (res []string) {
+		for _, s := range et.Sources {
+			res = append(res, s.Type)
+		}
+		return
+	}
+
+	vod, _ := r.Index().Path("/Movie/movie.mp4")
+	et, err := tb.Build(r, &vod, testParams{})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"application/vnd.apple.mpegurl", "application/dash+xml"}, types(et))
+		assert.Contains(t, et.Sources[0].Src, "~vod/hls/")
+		assert.True(t, strings.Contains(et.Sources[1].Src, "~vod/dash/") && strings.Contains(et.Sources[1].Src, "/index.mpd"))
+	}
+
+	transcode, _ := r.Index().Path("/Movie/movie.mkv")
+	et, err = tb.Build(r, &transcode, testParams{"stream-format": {"dash"}})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"application/dash+xml", "application/vnd.apple.mpegurl"}, types(et))
+		assert.Contains(t, et.Sources[0].Src, "~hls/index.mpd")
+		assert.Contains(t, et.Sources[1].Src, "~hls/index.m3u8")
+	}
+
+	// Direct file urls have no manifest to offer in another format.
+	direct, _ := r.Index().Path("/Movie/track.mp3")
+	et, err = tb.Build(r, &direct, testParams{})
+	if assert.Nil(t, err) {
+		assert.Len(t, et.Sources, 1)
+	}
+
+	_, err = tb.Build(r, &vod, testParams{"stream-format": {"smooth"}})
+	assert.Equal(t, ErrorCodeBadRequest, ErrorCodeOf(err))
+}
+
+func TestTagBuilder_subtitleTracks(t *testing.T) {
+	tb, _ := testTagBuilder(t)
+	r := &Resource{
+		ID:   manifestHash,
+		Name: "Anime",
+		Files: []*File{
+			{Path: []string{"Anime", "ep01.mkv"}, Size: 1},
+			{Path: []string{"Anime", "ep01.en.ass"}, Size: 1},
+			{Path: []string{"Anime", "ep01.ja.ssa"}, Size: 1},
+			{Path: []string{"Anime", "ep01.de.srt"}, Size: 1},
+			{Path: []string{"Anime", "ep01.fr.sub"}, Size: 1},
+			{Path: []string{"Anime", "ep01.sub"}, Size: 1},
+			{Path: []string{"Anime", "ep01.idx"}, Size: 1},
+		},
+	}
+	ep, _ := r.Index().Path("/Anime/ep01.mkv")
+	et, err := tb.Build(r, &ep, testParams{})
+	if !assert.Nil(t, err) {
+		return
+	}
+	var srcs []string
+	for _, tr := range et.Tracks {
+		srcs = append(srcs, tr.Src[strings.Index(tr.Src, "~"):])
+	}
+	// The VobSub pair is bitmap based and is not attached.
+	assert.Equal(t, []string{
+		"~vtt/ep01.en.vtt",
+		"~vtt/ep01.ja.vtt",
+		"~vtt/ep01.de.vtt",
+		"~vtt/ep01.fr.vtt",
+	}, srcs)
+	assert.Equal(t, "en", et.Tracks[0].SrcLang)
+
+	vobsub, _ := r.Index().Path("/Anime/ep01.sub")
+	assert.True(t, isVobSub(r, &vobsub))
+	microdvd, _ := r.Index().Path("/Anime/ep01.fr.sub")
+	assert.False(t, isVobSub(r, &microdvd))
+	assert.Equal(t, Subtitle, vobsub.MediaFormat)
+}
+
+func TestTagBuilder_sceneRelease(t *testing.T) {
+	tb, _ := testTagBuilder(t)
+	r := &Resource{
+		ID:   manifestHash,
+		Name: "Movie.2020",
+		Files: []*File{
+			{Path: []string{"Movie.2020", "Movie.2020.mkv"}, Size: 1},
+			{Path: []string{"Movie.2020", "Movie.2020.en.forced.srt"}, Size: 1},
+			{Path: []string{"Movie.2020", "Subs", "2_English.srt"}, Size: 1},
+			{Path: []string{"Movie.2020", "Subs", "eng.sdh.srt"}, Size: 1},
+			{Path: []string{"Movie.2020", "Subs", "fra.hi.srt"}, Size: 1},
+			{Path: []string{"Movie.2020", "screen.jpg"}, Size: 1},
+			{Path: []string{"Movie.2020", "Cover.jpg"}, Size: 1},
+			{Path: []string{"Movie.2020", "Movie.2020.jpg"}, Size: 1},
+		},
+	}
+	v, _ := r.Index().Path("/Movie.2020/Movie.2020.mkv")
+	et, err := tb.Build(r, &v, testParams{})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, []ExportTrack{
+		{Kind: ExportKindTypeSubtitles, SrcLang: "en", Label: "English (Forced)", Forced: true},
+		{Kind: ExportKindTypeSubtitles, SrcLang: "en", Label: "English"},
+		{Kind: ExportKindTypeCaptions, SrcLang: "en", Label: "English (SDH)", SDH: true},
+		{Kind: ExportKindTypeCaptions, SrcLang: "fr", Label: "French (SDH)", SDH: true},
+	}, tracksWithoutSrc(et.Tracks))
+	assert.Contains(t, et.Poster, "/Movie.2020/Movie.2020.jpg")
+}
+
+func TestTagBuilder_seasonSubsFolders(t *testing.T) {
+	tb, _ := testTagBuilder(t)
+	r := &Resource{
+		ID:   manifestHash,
+		Name: "Show.S01",
+		Files: []*File{
+			{Path: []string{"Show.S01", "Show.S01E01.mkv"}, Size: 1},
+			{Path: []string{"Show.S01", "Show.S01E02.mkv"}, Size: 1},
+			{Path: []string{"Show.S01", "folder.jpg"}, Size: 1},
+			{Path: []string{"Show.S01", "Subtitles", "Show.S01E01.de.srt"}, Size: 1},
+			{Path: []string{"Show.S01", "Subtitles", "Show.S01E02.de.srt"}, Size: 1},
+			{Path: []string{"Show.S01", "Subs", "Show.S01E01", "3_Spanish.srt"}, Size: 1},
+			{Path: []string{"Show.S01", "Subs", "Show.S01E02", "3_Spanish.srt"}, Size: 1},
+		},
+	}
+	v, _ := r.Index().Path("/Show.S01/Show.S01E01.mkv")
+	et, err := tb.Build(r, &v, testParams{})
+	if !assert.Nil(t, err) {
+		return
+	}
+	if assert.Len(t, et.Tracks, 2) {
+		assert.Contains(t, et.Tracks[0].Src, "/Subtitles/Show.S01E01.de.srt~vtt/")
+		assert.Equal(t, "de", et.Tracks[0].SrcLang)
+		assert.Contains(t, et.Tracks[1].Src, "/Subs/Show.S01E01/3_Spanish.srt~vtt/")
+		assert.Equal(t, "Spanish", et.Tracks[1].Label)
+	}
+	assert.Contains(t, et.Poster, "/folder.jpg")
+}
+
+func tracksWithoutSrc(tt []ExportTrack) []ExportTrack {
+	res := make([]ExportTrack, len(tt))
+	for n, t := range tt {
+		t.Src = ""
+		res[n] = t
+	}
+	return res
+}
+
+func TestTagBuilder_defaultTrack(t *testing.T) {
+	tb, _ := testTagBuilder(t)
+	r := &Resource{
+		ID:   manifestHash,
+		Name: "Movie",
+		Files: []*File{
+			{Path: []string{"Movie", "Movie.mkv"}, Size: 1},
+			{Path: []string{"Movie", "Movie.en.srt"}, Size: 1},
+			{Path: []string{"Movie", "Movie.ru.forced.srt"}, Size: 1},
+			{Path: []string{"Movie", "Movie_rus.srt"}, Size: 1},
+			{Path: []string{"Movie", "Subs", "Brazilian Portuguese", "1.srt"}, Size: 1},
+		},
+	}
+	v, _ := r.Index().Path("/Movie/Movie.mkv")
+	defaults := func(g testParams) (res []string) {
+		et, err := tb.Build(r, &v, g)
+		assert.Nil(t, err)
+		for _, tr := range et.Tracks {
+			if tr.Default {
+				res = append(res, tr.Label)
+			}
+		}
+		return
+	}
+	et, err := tb.Build(r, &v, testParams{})
+	if assert.Nil(t, err) && assert.Len(t, et.Tracks, 4) {
+		assert.Equal(t, "pt-BR", et.Tracks[3].SrcLang)
+		assert.Equal(t, "Brazilian Portuguese", et.Tracks[3].Label)
+	}
+	assert.Empty(t, defaults(testParams{}))
+	assert.Equal(t, []string{"Russian"}, defaults(testParams{"lang": {"russian"}}))
+	assert.Equal(t, []string{"Brazilian Portuguese"}, defaults(testParams{"lang": {"pt"}}))
+	assert.Empty(t, defaults(testParams{"lang": {"ja"}}))
+}
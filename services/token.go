@@ -0,0 +1,460 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"sigs.k8s.io/yaml"
+)
+
+const tokenKeysFileFlag = "token-keys-file"
+
+func RegisterTokenFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   tokenKeysFileFlag,
+			Usage:  "YAML or JSON file with the keys tokens are signed and verified with",
+			Value:  "",
+			EnvVar: "TOKEN_KEYS_FILE",
+		},
+	)
+}
+
+// tokenLeeway backdates the nbf claim of minted tokens, so services with a
+// clock slightly behind accept them right away.
+const tokenLeeway = time.Minute
+
+// TokenKeyConfig is a key of the token keys file. HS256 keys have a secret,
+// RS256 and EdDSA keys a PEM private key, or just a public key to verify
+// tokens signed elsewhere.
+type TokenKeyConfig struct {
+	Kid        string `json:"kid"`
+	Alg        string `json:"alg"`
+	Secret     string `json:"secret,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"`
+	PublicKey  string `json:"public_key,omitempty"`
+}
+
+// TokenKeysConfig is the token keys file. Tokens are signed with the
+// SigningKid key and verified with any of the keys, picked by the kid
+// header.
+type TokenKeysConfig struct {
+	SigningKid string           `json:"signing_kid"`
+	Keys       []TokenKeyConfig `json:"keys"`
+}
+
+// TokenKey is a key tokens are verified and, if it is private, signed with.
+type TokenKey struct {
+	Kid    string
+	method jwt.SigningMethod
+	sign   interface{}
+	verify interface{}
+}
+
+// newSecretKey returns an HS256 key signing with secret.
+func newSecretKey(kid string, secret string) *TokenKey {
+	return &TokenKey{Kid: kid, method: jwt.SigningMethodHS256, sign: []byte(secret), verify: []byte(secret)}
+}
+
+func newTokenKey(c TokenKeyConfig) (*TokenKey, error) {
+	k := &TokenKey{Kid: c.Kid}
+	var err error
+	switch c.Alg {
+	case jwt.SigningMethodHS256.Alg():
+		if c.Secret == "" {
+			return nil, errors.Errorf("no secret for key %q", c.Kid)
+		}
+		k = newSecretKey(c.Kid, c.Secret)
+	case jwt.SigningMethodRS256.Alg():
+		k.method = jwt.SigningMethodRS256
+		if c.PrivateKey != "" {
+			var pk *rsa.PrivateKey
+			pk, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(c.PrivateKey))
+			if err == nil {
+				k.sign = pk
+				k.verify = &pk.PublicKey
+			}
+		} else {
+			k.verify, err = jwt.ParseRSAPublicKeyFromPEM([]byte(c.PublicKey))
+		}
+	case jwt.SigningMethodEdDSA.Alg():
+		k.method = jwt.SigningMethodEdDSA
+		if c.PrivateKey != "" {
+			var pk interface{}
+			pk, err = jwt.ParseEdPrivateKeyFromPEM([]byte(c.PrivateKey))
+			if err == nil {
+				k.sign = pk
+				k.verify = pk.(ed25519.PrivateKey).Public()
+			}
+		} else {
+			k.verify, err = jwt.ParseEdPublicKeyFromPEM([]byte(c.PublicKey))
+		}
+	default:
+		return nil, errors.Errorf("unsupported alg %q of key %q", c.Alg, c.Kid)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse key %q", c.Kid)
+	}
+	return k, nil
+}
+
+// JWK is a public key of the JWKS endpoint.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// jwk returns the public key as a JWK, false for HS256 keys.
+func (s *TokenKey) jwk() (JWK, bool) {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch pk := s.verify.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: s.Kid,
+			Use: "sig",
+			Alg: s.method.Alg(),
+			N:   enc(pk.N.Bytes()),
+			E:   enc(big.NewInt(int64(pk.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Kid: s.Kid,
+			Use: "sig",
+			Alg: s.method.Alg(),
+			Crv: "Ed25519",
+			X:   enc(pk),
+		}, true
+	}
+	return JWK{}, false
+}
+
+// requestClaims are the claims the tokens of a request are minted from: the
+// request token's, or the configured role's if there is none.
+type requestClaims struct {
+	claims   jwt.MapClaims
+	issuedAt time.Time
+}
+
+// tokenCache is implemented by ParamGetters shared by several Build calls of
+// one request, so the request token is parsed just once and all of its urls
+// expire at the same time.
+type tokenCache interface {
+	cachedClaims(f func() (*requestClaims, error)) (*requestClaims, error)
+}
+
+// Tokens parses request tokens and mints the tokens of exported and
+// speedtest urls. Without a signing key, request tokens are passed on as is.
+type Tokens struct {
+	keys    map[string]*TokenKey
+	signing *TokenKey
+	role    string
+	ttl     time.Duration
+	bindIP  bool
+	strict  bool
+}
+
+// NewTokens loads the keys of the token keys file. The export api secret,
+// if set, is an HS256 key without kid, signing tokens unless the file has a
+// signing key.
+func NewTokens(c *cli.Context) (*Tokens, error) {
+	s := &Tokens{
+		keys:   map[string]*TokenKey{},
+		role:   c.String(exportApiRoleFlag),
+		ttl:    c.Duration(exportTokenTTLFlag),
+		bindIP: c.Bool(exportTokenBindIPFlag),
+		strict: c.Bool(exportTokenStrictFlag),
+	}
+	if p := c.String(tokenKeysFileFlag); p != "" {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read token keys file %v", p)
+		}
+		if err := s.load(b); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse token keys file %v", p)
+		}
+	}
+	if secret := c.String(exportApiSecretFlag); secret != "" {
+		if _, ok := s.keys[""]; ok {
+			return nil, errors.Errorf("token key without kid conflicts with the export api secret")
+		}
+		s.keys[""] = newSecretKey("", secret)
+		if s.signing == nil {
+			s.signing = s.keys[""]
+		}
+	}
+	return s, nil
+}
+
+func (s *Tokens) load(b []byte) error {
+	var cfg TokenKeysConfig
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return err
+	}
+	for _, kc := range cfg.Keys {
+		if _, ok := s.keys[kc.Kid]; ok {
+			return errors.Errorf("duplicate key %q", kc.Kid)
+		}
+		k, err := newTokenKey(kc)
+		if err != nil {
+			return err
+		}
+		s.keys[kc.Kid] = k
+	}
+	if cfg.SigningKid != "" {
+		k, ok := s.keys[cfg.SigningKid]
+		if !ok || k.sign == nil {
+			return errors.Errorf("no private key %q to sign with", cfg.SigningKid)
+		}
+		s.signing = k
+	}
+	return nil
+}
+
+// Enabled reports whether tokens are minted.
+func (s *Tokens) Enabled() bool {
+	return s.signing != nil
+}
+
+// Parse verifies the token with the key of its kid header.
+func (s *Tokens) Parse(tokenString string) (jwt.MapClaims, error) {
+	return parseToken(tokenString, func(kid string) (*TokenKey, bool) {
+		k, ok := s.keys[kid]
+		return k, ok
+	})
+}
+
+// Parse verifies the token with the key, whatever its kid header.
+func (s *TokenKey) Parse(tokenString string) (jwt.MapClaims, error) {
+	return parseToken(tokenString, func(string) (*TokenKey, bool) {
+		return s, true
+	})
+}
+
+func parseToken(tokenString string, key func(kid string) (*TokenKey, bool)) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		k, ok := key(kid)
+		if !ok {
+			return nil, errors.Errorf("unknown key %q", kid)
+		}
+		if t.Method.Alg() != k.method.Alg() {
+			return nil, errors.Errorf("unexpected signing method=%v", t.Header["alg"])
+		}
+		return k.verify, nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse token")
+	}
+	return claims, nil
+}
+
+// Sign signs the claims with the signing key.
+func (s *Tokens) Sign(claims jwt.MapClaims) (string, error) {
+	if s.signing == nil {
+		return "", errors.Errorf("no key to sign tokens with")
+	}
+	t := jwt.NewWithClaims(s.signing.method, claims)
+	if s.signing.Kid != "" {
+		t.Header["kid"] = s.signing.Kid
+	}
+	return t.SignedString(s.signing.sign)
+}
+
+// JWKS returns the public keys, so services can verify tokens without
+// sharing a secret.
+func (s *Tokens) JWKS() *JWKS {
+	res := &JWKS{Keys: []JWK{}}
+	for _, k := range s.keys {
+		if j, ok := k.jwk(); ok {
+			res.Keys = append(res.Keys, j)
+		}
+	}
+	// Sorted, so the body stays the same and caches well.
+	sort.Slice(res.Keys, func(i, j int) bool {
+		return res.Keys[i].Kid < res.Keys[j].Kid
+	})
+	return res
+}
+
+func getRequestToken(g ParamGetter) string {
+	if t := g.Query("token"); t != "" {
+		return t
+	}
+	return g.GetHeader("X-Token")
+}
+
+func getUserID(g ParamGetter) string {
+	if id := g.Query("user-id"); id != "" {
+		return id
+	}
+	return g.GetHeader("X-User-Id")
+}
+
+// clientIP returns the ip of the client, if g knows it.
+func clientIP(g ParamGetter) string {
+	if c, ok := g.(interface{ ClientIP() string }); ok {
+		return c.ClientIP()
+	}
+	return ""
+}
+
+// RequestClaims returns the claims of the request token, or the configured
+// role if there is none. The token of an authenticated api key is taken as
+// verified by Auth.
+func (s *Tokens) RequestClaims(g ParamGetter) (*requestClaims, error) {
+	if tc, ok := g.(tokenCache); ok {
+		return tc.cachedClaims(func() (*requestClaims, error) {
+			return s.parseRequestClaims(g)
+		})
+	}
+	return s.parseRequestClaims(g)
+}
+
+func (s *Tokens) parseRequestClaims(g ParamGetter) (*requestClaims, error) {
+	rc := &requestClaims{
+		claims:   jwt.MapClaims{},
+		issuedAt: time.Now().Truncate(time.Second),
+	}
+	// The token of an api key is verified by Auth with the key's secret,
+	// not with the export keys.
+	if claims := authClaims(g); claims != nil {
+		rc.claims = claims
+		return rc, nil
+	}
+	t := getRequestToken(g)
+	// Without keys, request tokens can't be verified and are opaque.
+	if t != "" && len(s.keys) > 0 {
+		claims, err := s.Parse(t)
+		if err == nil {
+			rc.claims = claims
+			return rc, nil
+		}
+		err = wrapInvalidToken(err, "invalid token")
+		if s.strict {
+			return nil, err
+		}
+		// Out of strict mode, urls are minted for the configured role
+		// instead. Requests share their claims through sharedTokenParams,
+		// so this is logged once per request.
+		log.WithError(err).Warnf("falling back to role %q", s.role)
+	}
+	if s.role != "" {
+		rc.claims["role"] = s.role
+	}
+	return rc, nil
+}
+
+// Role returns the role urls of the request are minted for. An invalid
+// request token is rejected in strict mode and falls back to the configured
+// role otherwise.
+func (s *Tokens) Role(g ParamGetter) (string, error) {
+	rc, err := s.RequestClaims(g)
+	if err != nil {
+		return "", err
+	}
+	r, _ := rc.claims["role"].(string)
+	return r, nil
+}
+
+// Mint returns the token of a url and its expiry. Minted tokens are bound
+// to the client and, unless r is nil, to the content. Without a signing key
+// the request token is passed on as is.
+func (s *Tokens) Mint(g ParamGetter, r *Resource, i *ListItem) (string, time.Time, error) {
+	if !s.Enabled() {
+		return getRequestToken(g), time.Time{}, nil
+	}
+	rc, err := s.RequestClaims(g)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	claims, exp, err := s.scopedClaims(g, rc, r, i)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	token, err := s.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, exp, nil
+}
+
+// scopedClaims binds the request claims to the content, their lifetime and
+// the client. A request token already bound to some content can only mint
+// tokens for that content.
+func (s *Tokens) scopedClaims(g ParamGetter, rc *requestClaims, r *Resource, i *ListItem) (jwt.MapClaims, time.Time, error) {
+	claims := jwt.MapClaims{}
+	for k, v := range rc.claims {
+		claims[k] = v
+	}
+	if r != nil {
+		if h, ok := rc.claims["hash"].(string); ok && h != r.ID {
+			return nil, time.Time{}, errForbidden(errors.Errorf("token is not valid for resource %v", r.ID))
+		}
+		if p, ok := rc.claims["path"].(string); ok && !pathWithin(i.PathStr, p) {
+			return nil, time.Time{}, errForbidden(errors.Errorf("token is not valid for path %v", i.PathStr))
+		}
+		claims["hash"] = r.ID
+		claims["path"] = i.PathStr
+	}
+	claims["iat"] = rc.issuedAt.Unix()
+	claims["nbf"] = rc.issuedAt.Add(-tokenLeeway).Unix()
+	exp := s.expiry(rc)
+	if !exp.IsZero() {
+		claims["exp"] = exp.Unix()
+	}
+	if _, ok := claims["user_id"]; !ok {
+		if id := getUserID(g); id != "" {
+			claims["user_id"] = id
+		}
+	}
+	if _, ok := claims["ip"]; !ok && s.bindIP {
+		if ip := clientIP(g); ip != "" {
+			claims["ip"] = ip
+		}
+	}
+	return claims, exp, nil
+}
+
+// expiry returns when minted tokens expire, zero if never. They never
+// outlive the request token.
+func (s *Tokens) expiry(rc *requestClaims) time.Time {
+	var exp time.Time
+	if s.ttl > 0 {
+		exp = rc.issuedAt.Add(s.ttl)
+	}
+	if e, err := rc.claims.GetExpirationTime(); err == nil && e != nil {
+		if exp.IsZero() || e.Before(exp) {
+			exp = e.Time
+		}
+	}
+	return exp
+}
+
+// pathWithin reports whether content path p is dir or lies beneath it.
+func pathWithin(p string, dir string) bool {
+	dir = strings.TrimRight(dir, "/")
+	return dir == "" || p == dir || strings.HasPrefix(p, dir+"/")
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// testTokens returns Tokens signing with an HS256 secret, passing request
+// tokens on as is if the secret is empty.
+func testTokens(secret string, ttl time.Duration) *Tokens {
+	s := &Tokens{keys: map[string]*TokenKey{}, role: "free", ttl: ttl}
+	if secret != "" {
+		k := newSecretKey("", secret)
+		s.keys[""] = k
+		s.signing = k
+	}
+	return s
+}
+
+func testPEM(t *testing.T, key interface{}) string {
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}))
+}
+
+func testPublicPEM(t *testing.T, key interface{}) string {
+	b, err := x509.MarshalPKIXPublicKey(key)
+	assert.Nil(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}))
+}
+
+func testKeysFile(t *testing.T, cfg TokenKeysConfig) []byte {
+	b, err := json.Marshal(cfg)
+	assert.Nil(t, err)
+	return b
+}
+
+func TestTokens_rotation(t *testing.T) {
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	_, ek, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	old := testTokens("", 0)
+	assert.Nil(t, old.load(testKeysFile(t, TokenKeysConfig{
+		SigningKid: "2024-07",
+		Keys: []TokenKeyConfig{
+			{Kid: "2024-07", Alg: "RS256", PrivateKey: testPEM(t, rk)},
+		},
+	})))
+	oldToken, err := old.Sign(jwt.MapClaims{"role": "paid"})
+	assert.Nil(t, err)
+
+	// The new key signs, the old one still verifies tokens in flight.
+	s := testTokens("", 0)
+	assert.Nil(t, s.load(testKeysFile(t, TokenKeysConfig{
+		SigningKid: "2024-10",
+		Keys: []TokenKeyConfig{
+			{Kid: "2024-10", Alg: "EdDSA", PrivateKey: testPEM(t, ek)},
+			{Kid: "2024-07", Alg: "RS256", PublicKey: testPublicPEM(t, &rk.PublicKey)},
+			{Kid: "shared", Alg: "HS256", Secret: "s3cret"},
+		},
+	})))
+	claims, err := s.Parse(oldToken)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "paid", claims["role"])
+	}
+	newToken, err := s.Sign(jwt.MapClaims{"role": "free"})
+	if assert.Nil(t, err) {
+		tok, _, _ := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+		assert.Equal(t, "2024-10", tok.Header["kid"])
+		assert.Equal(t, "EdDSA", tok.Method.Alg())
+		_, err = s.Parse(newToken)
+		assert.Nil(t, err)
+	}
+	_, err = old.Parse(newToken)
+	assert.NotNil(t, err)
+
+	jwks := s.JWKS()
+	kids := map[string]JWK{}
+	for _, k := range jwks.Keys {
+		kids[k.Kid] = k
+	}
+	assert.Len(t, kids, 2)
+	assert.Equal(t, "2024-07", jwks.Keys[0].Kid)
+	assert.Equal(t, "OKP", kids["2024-10"].Kty)
+	assert.Equal(t, "Ed25519", kids["2024-10"].Crv)
+	assert.Equal(t, "RSA", kids["2024-07"].Kty)
+	assert.Equal(t, "AQAB", kids["2024-07"].E)
+}
+
+func TestTokens_Parse(t *testing.T) {
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	s := testTokens("legacy", 0)
+	assert.Nil(t, s.load(testKeysFile(t, TokenKeysConfig{
+		Keys: []TokenKeyConfig{
+			{Kid: "rsa", Alg: "RS256", PublicKey: testPublicPEM(t, &rk.PublicKey)},
+		},
+	})))
+	sign := func(m jwt.SigningMethod, kid string, key interface{}) string {
+		tok := jwt.NewWithClaims(m, jwt.MapClaims{})
+		if kid != "" {
+			tok.Header["kid"] = kid
+		}
+		res, err := tok.SignedString(key)
+		assert.Nil(t, err)
+		return res
+	}
+
+	// Tokens without kid are verified with the export api secret.
+	_, err = s.Parse(sign(jwt.SigningMethodHS256, "", []byte("legacy")))
+	assert.Nil(t, err)
+	_, err = s.Parse(sign(jwt.SigningMethodRS256, "rsa", rk))
+	assert.Nil(t, err)
+	_, err = s.Parse(sign(jwt.SigningMethodRS256, "unknown", rk))
+	assert.NotNil(t, err)
+	// An HS256 token keyed with the public key must not pass for RS256.
+	_, err = s.Parse(sign(jwt.SigningMethodHS256, "rsa", []byte(testPublicPEM(t, &rk.PublicKey))))
+	assert.NotNil(t, err)
+	expired, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}).SignedString([]byte("legacy"))
+	_, err = s.Parse(expired)
+	assert.NotNil(t, err)
+	assert.Len(t, s.JWKS().Keys, 1)
+}
+
+func TestTokens_load(t *testing.T) {
+	assert.NotNil(t, testTokens("", 0).load([]byte(`{"signing_kid": "a", "keys": [{"kid": "a", "alg": "RS256", "public_key": "x"}]}`)))
+	assert.NotNil(t, testTokens("", 0).load([]byte(`{"keys": [{"kid": "a", "alg": "none"}]}`)))
+	assert.NotNil(t, testTokens("", 0).load([]byte(`{"keys": [{"kid": "a", "alg": "HS256"}]}`)))
+	err := testTokens("", 0).load([]byte(`{"keys": [{"kid": "a", "alg": "HS256", "secret": "x"}, {"kid": "a", "alg": "HS256", "secret": "y"}]}`))
+	if assert.NotNil(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "duplicate"))
+	}
+}
+
+func TestTokens_strict(t *testing.T) {
+	s := testTokens("secret", 0)
+	bad := testParams{"token": {"not-a-token"}}
+
+	role, err := s.Role(bad)
+	assert.Nil(t, err)
+	assert.Equal(t, "free", role)
+	// The urls carry a token of the fallback role, not the broken one.
+	token, _, err := s.Mint(bad, nil, nil)
+	if assert.Nil(t, err) {
+		claims, err := s.Parse(token)
+		if assert.Nil(t, err) {
+			assert.Equal(t, "free", claims["role"])
+		}
+	}
+
+	s.strict = true
+	_, err = s.Role(bad)
+	assert.Equal(t, ErrorCodeInvalidToken, ErrorCodeOf(err))
+	_, _, err = s.Mint(bad, nil, nil)
+	assert.Equal(t, ErrorCodeInvalidToken, ErrorCodeOf(err))
+
+	good, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"role": "paid"}).SignedString([]byte("secret"))
+	role, err = s.Role(testParams{"token": {good}})
+	assert.Nil(t, err)
+	assert.Equal(t, "paid", role)
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	ts "github.com/webtor-io/torrent-store/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 )
 
@@ -57,6 +58,8 @@ func (s *TorrentStore) get() (ts.TorrentStoreClient, error) {
 	addr := fmt.Sprintf("%s:%d", s.host, s.port)
 	conn, err := grpc.NewClient(addr,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(grpcMetricsInterceptor("torrent-store")),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 		grpc.WithDefaultCallOptions(
 			grpc.MaxCallRecvMsgSize(torrentStoreMaxMsgSize),
 			grpc.MaxCallSendMsgSize(torrentStoreMaxMsgSize),
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	otlpEndpointFlag     = "otlp-endpoint"
+	otlpInsecureFlag     = "otlp-insecure"
+	useStdoutTracingFlag = "use-stdout-tracing"
+	tracingServiceName   = "rest-api"
+)
+
+// tracer goes through the global provider, so spans started before
+// NewTracing (or without it at all) are simply no-ops.
+var tracer = otel.Tracer("github.com/webtor-io/rest-api/services")
+
+func init() {
+	// Trace context is always propagated, even when this process exports
+	// nothing, so upstream and downstream spans still join.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
+
+func RegisterTracingFlags(f []cli.Flag) []cli.Flag {
+	return append(f,
+		cli.StringFlag{
+			Name:   otlpEndpointFlag,
+			Usage:  "OTLP gRPC collector address (host:port), tracing export is disabled if empty",
+			Value:  "",
+			EnvVar: "OTLP_ENDPOINT",
+		},
+		cli.BoolFlag{
+			Name:   otlpInsecureFlag,
+			Usage:  "disable TLS for the OTLP collector connection",
+			EnvVar: "OTLP_INSECURE",
+		},
+		cli.BoolFlag{
+			Name:   useStdoutTracingFlag,
+			Usage:  "print spans to stdout",
+			EnvVar: "USE_STDOUT_TRACING",
+		},
+	)
+}
+
+type Tracing struct {
+	tp *sdktrace.TracerProvider
+}
+
+func NewTracing(c *cli.Context) (*Tracing, error) {
+	var opts []sdktrace.TracerProviderOption
+	if endpoint := c.String(otlpEndpointFlag); endpoint != "" {
+		eopts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+		if c.Bool(otlpInsecureFlag) {
+			eopts = append(eopts, otlptracegrpc.WithInsecure())
+		}
+		exp, err := otlptracegrpc.New(context.Background(), eopts...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to init otlp exporter endpoint=%v", endpoint)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	}
+	if c.Bool(useStdoutTracingFlag) {
+		exp, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to init stdout exporter")
+		}
+		opts = append(opts, sdktrace.WithSyncer(exp))
+	}
+	if len(opts) == 0 {
+		return nil, nil
+	}
+	opts = append(opts, sdktrace.WithResource(resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(tracingServiceName),
+	)))
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	return &Tracing{tp: tp}, nil
+}
+
+func (s *Tracing) Close() {
+	log.Info("closing Tracing")
+	defer func() {
+		log.Info("Tracing closed")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.tp.Shutdown(ctx); err != nil {
+		log.WithError(err).Warn("failed to flush spans")
+	}
+}
+
+// endSpan records err on the span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingHandler is the gin middleware starting a server span per request.
+// The incoming trace context is honored and the span is put into the
+// request context, so everything downstream (gRPC calls, cache probes)
+// becomes its child.
+func tracingHandler(c *gin.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	route := c.FullPath()
+	name := c.Request.Method
+	if route != "" {
+		name += " " + route
+	}
+	ctx, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Request.Method),
+			semconv.URLPath(c.Request.URL.Path),
+			semconv.HTTPRoute(route),
+		),
+	)
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+	status := c.Writer.Status()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if len(c.Errors) > 0 {
+		span.RecordError(c.Errors[0].Err, trace.WithAttributes(
+			attribute.String("error.code", string(ErrorCodeOf(c.Errors[0].Err))),
+		))
+	}
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, fmt.Sprintf("status %v", status))
+	}
+}
+
+// detachedSpanContext carries the span of ctx, but none of its deadline or
+// cancellation. Used for work shared through a lazymap, whose lifetime must
+// not depend on the request that happened to trigger it.
+func detachedSpanContext(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+}
+
+// requestContext returns the context of the request behind g. A gin.Context
+// falls back to its request context (Web sets ContextWithFallback), so the
+// request span reaches calls that only get a ParamGetter.
+func requestContext(g ParamGetter) context.Context {
+	if ctx, ok := g.(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
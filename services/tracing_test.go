@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func testSpanContext(t *testing.T) trace.SpanContext {
+	tid, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.Nil(t, err)
+	sid, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	assert.Nil(t, err)
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tid,
+		SpanID:     sid,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+}
+
+func TestCacheMap_propagatesTraceContext(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	sc := testSpanContext(t)
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), sc)
+	cm := newTestCacheMap(srv.Client(), time.Second)
+	_, err := cm.Get(ctx, testCacheMapURL(t, srv.URL, "/traced"))
+	assert.Nil(t, err)
+	assert.Contains(t, got, sc.TraceID().String())
+}
+
+func TestTracingHandler_extractsTraceContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var got trace.SpanContext
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(tracingHandler)
+	r.GET("/resource/:resource_id", func(c *gin.Context) {
+		got = trace.SpanContextFromContext(requestContext(c))
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/resource/"+manifestHash, nil)
+	req.Header.Set("traceparent", testTraceparent)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, testSpanContext(t).TraceID(), got.TraceID())
+}
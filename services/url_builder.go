@@ -4,14 +4,18 @@ import (
 	"crypto/sha1"
 	"fmt"
 	"net/url"
+	"path/filepath"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/dgrijalva/jwt-go"
-	"github.com/pkg/errors"
+	"github.com/gin-gonic/gin"
 
 	"github.com/urfave/cli"
+	"golang.org/x/text/unicode/norm"
 )
 
 type MyURL struct {
@@ -20,6 +24,13 @@ type MyURL struct {
 	transcode       bool
 	multibitrate    bool
 	transcodeCached bool
+	// streamFormat is the adaptive streaming format of the manifest the url
+	// points to, empty for direct file urls.
+	streamFormat StreamFormat
+	// expiresAt is when the url's token expires, zero if never.
+	expiresAt time.Time
+	// role is the role the url was minted for.
+	role string
 }
 
 func (s *MyURL) BuildExportMeta() *ExportMeta {
@@ -27,6 +38,7 @@ func (s *MyURL) BuildExportMeta() *ExportMeta {
 		Cache:          s.cached,
 		Transcode:      s.transcode,
 		TranscodeCache: s.transcodeCached,
+		Role:           s.role,
 	}
 }
 
@@ -34,32 +46,32 @@ type URLBuilder struct {
 	sd                *Subdomains
 	cm                *CacheMap
 	domain            string
-	apiSecret         string
+	tk                *Tokens
 	apiKey            string
-	apiRole           string
 	useSubdomains     bool
 	subdomainsK8SPool string
 	pathPrefix        string
 	premiumDomain     string
+	usePreview        bool
 }
 
-func NewURLBuilder(c *cli.Context, sd *Subdomains, cm *CacheMap) *URLBuilder {
+func NewURLBuilder(c *cli.Context, sd *Subdomains, cm *CacheMap, tk *Tokens) *URLBuilder {
 	return &URLBuilder{
 		sd:                sd,
 		cm:                cm,
+		tk:                tk,
 		domain:            c.String(exportDomainFlag),
 		premiumDomain:     c.String(exportPremiumDomainFlag),
 		apiKey:            c.String(exportApiKeyFlag),
-		apiSecret:         c.String(exportApiSecretFlag),
-		apiRole:           c.String(exportApiRoleFlag),
 		useSubdomains:     c.BoolT(exportUseSubdomainsFlag),
 		subdomainsK8SPool: c.String(exportSubdomainsK8SPoolFlag),
 		pathPrefix:        c.String(exportPathPrefixFlag),
+		usePreview:        c.Bool(exportPreviewFlag),
 	}
 }
 
-func (s *URLBuilder) Build(r *Resource, i *ListItem, g ParamGetter, et ExportType) (*MyURL, error) {
-	bubc := BaseURLBuilder{
+func (s *URLBuilder) base(r *Resource, i *ListItem, g ParamGetter) BaseURLBuilder {
+	return BaseURLBuilder{
 		sd:                s.sd,
 		cm:                s.cm,
 		r:                 r,
@@ -67,14 +79,17 @@ func (s *URLBuilder) Build(r *Resource, i *ListItem, g ParamGetter, et ExportTyp
 		g:                 g,
 		domain:            s.domain,
 		premiumDomain:     s.premiumDomain,
+		tk:                s.tk,
 		apiKey:            s.apiKey,
-		apiSecret:         s.apiSecret,
-		apiRole:           s.apiRole,
 		useSubdomains:     s.useSubdomains,
 		subdomainsK8SPool: s.subdomainsK8SPool,
 		pathPrefix:        s.pathPrefix,
 		usePremiumDomain:  g.Query("use-premium-domain") != "false",
 	}
+}
+
+func (s *URLBuilder) Build(r *Resource, i *ListItem, g ParamGetter, et ExportType) (*MyURL, error) {
+	bubc := s.base(r, i, g)
 	switch et {
 	case ExportTypeDownload:
 		dub := &DownloadURLBuilder{
@@ -82,10 +97,11 @@ func (s *URLBuilder) Build(r *Resource, i *ListItem, g ParamGetter, et ExportTyp
 		}
 		return dub.Build()
 	case ExportTypeStream:
-		sub := &StreamURLBuilder{
-			BaseURLBuilder: bubc,
+		f, err := StreamFormatFromParams(g)
+		if err != nil {
+			return nil, err
 		}
-		return sub.Build()
+		return s.BuildStream(r, i, g, f)
 	case ExportTypeTorrentStat:
 		// Stats are an SSE stream consumed by the warmup watchdog in
 		// real time; routing via the premium edge adds an extra hop
@@ -103,10 +119,19 @@ func (s *URLBuilder) Build(r *Resource, i *ListItem, g ParamGetter, et ExportTyp
 			BaseURLBuilder: bubc,
 		}
 		return sub.Build()
+	case ExportTypePreview:
+		if !s.usePreview {
+			return nil, nil
+		}
+		sub := &PreviewURLBuilder{
+			BaseURLBuilder: bubc,
+		}
+		return sub.Build()
 	case ExportTypeMediaProbe:
 		sub := &MediaProbeURLBuilder{
 			StreamURLBuilder: StreamURLBuilder{
 				BaseURLBuilder: bubc,
+				format:         StreamFormatHLS,
 			},
 		}
 		return sub.Build()
@@ -114,6 +139,16 @@ func (s *URLBuilder) Build(r *Resource, i *ListItem, g ParamGetter, et ExportTyp
 	return nil, nil
 }
 
+// BuildStream builds the stream url of i in the given format, regardless of
+// the stream-format query.
+func (s *URLBuilder) BuildStream(r *Resource, i *ListItem, g ParamGetter, f StreamFormat) (*MyURL, error) {
+	sub := &StreamURLBuilder{
+		BaseURLBuilder: s.base(r, i, g),
+		format:         f,
+	}
+	return sub.Build()
+}
+
 type BaseURLBuilder struct {
 	sd                *Subdomains
 	cm                *CacheMap
@@ -121,9 +156,8 @@ type BaseURLBuilder struct {
 	i                 *ListItem
 	g                 ParamGetter
 	domain            string
-	apiSecret         string
+	tk                *Tokens
 	apiKey            string
-	apiRole           string
 	subdomainsK8SPool string
 	useSubdomains     bool
 	pathPrefix        string
@@ -137,6 +171,7 @@ type DownloadURLBuilder struct {
 
 type StreamURLBuilder struct {
 	BaseURLBuilder
+	format StreamFormat
 }
 
 type TorrentStatURLBuilder struct {
@@ -151,6 +186,10 @@ type MediaProbeURLBuilder struct {
 	StreamURLBuilder
 }
 
+type PreviewURLBuilder struct {
+	BaseURLBuilder
+}
+
 type ServiceType string
 
 const (
@@ -159,10 +198,51 @@ const (
 	ServiceTypeVOD       ServiceType = "vod"
 	ServiceTypeSRT2VTT   ServiceType = "vtt"
 	ServiceTypeVideoInfo ServiceType = "vi"
+	ServiceTypePreview   ServiceType = "pv"
 )
 
 const ServiceSeparator = "~"
 
+// StreamFormat is the adaptive streaming format of video and audio stream
+// urls, both served by the transcode and VOD services.
+type StreamFormat string
+
+const (
+	StreamFormatHLS  StreamFormat = "hls"
+	StreamFormatDASH StreamFormat = "dash"
+)
+
+var StreamFormats = []StreamFormat{
+	StreamFormatHLS,
+	StreamFormatDASH,
+}
+
+func StreamFormatFromParams(g ParamGetter) (StreamFormat, error) {
+	f := StreamFormat(g.Query("stream-format"))
+	if f == "" {
+		return StreamFormatHLS, nil
+	}
+	if !slices.Contains(StreamFormats, f) {
+		return "", errBadRequest("failed to parse stream format \"%v\"", f)
+	}
+	return f, nil
+}
+
+// Manifest returns the manifest file name of the format.
+func (s StreamFormat) Manifest() string {
+	if s == StreamFormatDASH {
+		return "index.mpd"
+	}
+	return "index.m3u8"
+}
+
+func (s StreamFormat) MimeType() string {
+	if s == StreamFormatDASH {
+		return "application/dash+xml"
+	}
+	return "application/vnd.apple.mpegurl"
+}
+
 func (s *BaseURLBuilder) getApiKey() string {
 	if s.g.Query("api-key") != "" {
 		return s.g.Query("api-key")
@@ -176,71 +256,66 @@ func (s *BaseURLBuilder) getApiKey() string {
 	return ""
 }
 
-func (s *BaseURLBuilder) getToken() (string, error) {
-	if s.g.Query("token") != "" {
-		return s.g.Query("token"), nil
-	}
-	if s.g.GetHeader("X-Token") != "" {
-		return s.g.GetHeader("X-Token"), nil
-	}
-	if s.apiSecret != "" {
-		claims := jwt.MapClaims{}
-		if s.apiRole != "" {
-			claims["role"] = s.apiRole
-		}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		tokenString, err := token.SignedString([]byte(s.apiSecret))
-		if err != nil {
-			return "", err
-		}
-		return tokenString, nil
-	}
-	return "", nil
-}
-
-func (s *BaseURLBuilder) getClaims() (jwt.MapClaims, error) {
-
-	tokenString, err := s.getToken()
-
-	if err != nil {
-		return nil, err
-	}
-
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.Errorf("unexpected signing method=%v", token.Header["alg"])
-		}
-		return []byte(s.apiSecret), nil
+// sharedTokenParams is the tokenCache of an export request, shared by its
+// goroutines along with one limit on how many of them run.
+type sharedTokenParams struct {
+	*gin.Context
+	claimsOnce sync.Once
+	claims     *requestClaims
+	claimsErr  error
+	limiter    fanOut
+}
+
+func newSharedTokenParams(g *gin.Context) *sharedTokenParams {
+	// gin fills its query cache lazily; fill it before the params are
+	// shared between goroutines.
+	_ = g.Query("")
+	return &sharedTokenParams{Context: g, limiter: make(fanOut, batchConcurrency)}
+}
+
+// sharedParams returns g ready to be shared between goroutines, and the
+// limit they share.
+func sharedParams(g ParamGetter) (ParamGetter, fanOut) {
+	switch p := g.(type) {
+	case *sharedTokenParams:
+		return p, p.limiter
+	case *gin.Context:
+		sp := newSharedTokenParams(p)
+		return sp, sp.limiter
+	}
+	return g, make(fanOut, batchConcurrency)
+}
+
+// fanOut limits the goroutines of a request. Work over the limit runs in
+// the caller's goroutine, so nested fan-outs, like the playlists of a batch
+// export, share the limit without waiting on each other.
+type fanOut chan struct{}
+
+// Go runs f in a new goroutine if the limit allows, else right away.
+func (s fanOut) Go(wg *sync.WaitGroup, f func()) {
+	wg.Add(1)
+	select {
+	case s <- struct{}{}:
+		go func() {
+			defer wg.Done()
+			defer func() { <-s }()
+			f()
+		}()
+	default:
+		defer wg.Done()
+		f()
+	}
+}
+
+func (s *sharedTokenParams) cachedClaims(f func() (*requestClaims, error)) (*requestClaims, error) {
+	s.claimsOnce.Do(func() {
+		s.claims, s.claimsErr = f()
 	})
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to parse token")
-	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return nil, errors.Wrapf(err, "failed to validate token")
-	}
-	return claims, nil
+	return s.claims, s.claimsErr
 }
 
 func (s *BaseURLBuilder) getRole() (string, error) {
-	claims, err := s.getClaims()
-	if err != nil {
-		return "", err
-	}
-	if r, ok := claims["role"].(string); ok {
-		return r, nil
-	}
-	return "", nil
-}
-
-func (s *BaseURLBuilder) getUserID() string {
-	if s.g.Query("user-id") != "" {
-		return s.g.Query("user-id")
-	}
-	if s.g.GetHeader("X-User-Id") != "" {
-		return s.g.GetHeader("X-User-Id")
-	}
-	return ""
+	return s.tk.Role(s.g)
 }
 
 func (s *BaseURLBuilder) getRequestID() string {
@@ -261,14 +336,20 @@ func (s *BaseURLBuilder) BuildBaseURL(i *MyURL) (u *MyURL, err error) {
 	if apiKey != "" {
 		q.Add("api-key", apiKey)
 	}
-	token, err := s.getToken()
+	role, err := s.getRole()
+	if err != nil {
+		return nil, err
+	}
+	u.role = role
+	token, exp, err := s.tk.Mint(s.g, s.r, s.i)
 	if err != nil {
 		return nil, err
 	}
 	if token != "" {
 		q.Add("token", token)
 	}
-	userID := s.getUserID()
+	u.expiresAt = exp
+	userID := getUserID(s.g)
 	if userID != "" {
 		q.Add("user-id", userID)
 	}
@@ -277,7 +358,7 @@ func (s *BaseURLBuilder) BuildBaseURL(i *MyURL) (u *MyURL, err error) {
 		q.Add("request-id", requestID)
 	}
 	u.RawQuery = q.Encode()
-	cached, err := s.cm.Get(u)
+	cached, err := s.cm.Get(requestContext(s.g), u)
 	if err != nil {
 		return nil, err
 	}
@@ -285,25 +366,28 @@ func (s *BaseURLBuilder) BuildBaseURL(i *MyURL) (u *MyURL, err error) {
 	return
 }
 
-func (s *BaseURLBuilder) getBaseDomain() string {
+func (s *BaseURLBuilder) getBaseDomain() (string, error) {
 	if s.domain == "" {
-		return ""
+		return "", nil
 	}
 	if s.premiumDomain != "" {
 		role, err := s.getRole()
 		if err != nil {
-			return ""
+			return "", err
 		}
 		if role != "free" && s.usePremiumDomain {
-			return s.premiumDomain
+			return s.premiumDomain, nil
 		}
 	}
-	return s.domain
+	return s.domain, nil
 }
 
 func (s *BaseURLBuilder) BuildScheme(i *MyURL) (u *MyURL, err error) {
 	u = i
-	domain := s.getBaseDomain()
+	domain, err := s.getBaseDomain()
+	if err != nil {
+		return nil, err
+	}
 	if domain == "" {
 		u.Scheme = "http"
 		return
@@ -318,7 +402,10 @@ func (s *BaseURLBuilder) BuildScheme(i *MyURL) (u *MyURL, err error) {
 
 func (s *BaseURLBuilder) BuildDomain(i *MyURL) (u *MyURL, err error) {
 	u = i
-	baseDomain := s.getBaseDomain()
+	baseDomain, err := s.getBaseDomain()
+	if err != nil {
+		return nil, err
+	}
 	if baseDomain == "" {
 		return u, nil
 	}
@@ -333,7 +420,7 @@ func (s *BaseURLBuilder) BuildDomain(i *MyURL) (u *MyURL, err error) {
 		if err != nil {
 			return nil, err
 		}
-		subs, err := s.sd.Get(s.r.ID, pool, role)
+		subs, err := s.sd.Get(requestContext(s.g), s.r.ID, pool, role)
 		if err != nil {
 			return nil, err
 		}
@@ -369,49 +456,41 @@ const maxSelectedPathsEncodedLen = 6000
 // against. Sorting makes the selection a set so the signed URL (and the
 // archiver ETag behind it) doesn't depend on client ordering.
 //
-// Error texts deliberately carry the errorHandler mapping substrings
-// ("failed to parse" → 400, "not found" → 404): these are client-input
-// errors and must not surface as 5xx.
+// These are client-input errors: they are typed as bad request / not found
+// so they never surface as 5xx.
 func (s *DownloadURLBuilder) getSelectedPaths() ([]string, error) {
 	values := s.g.QueryArray("paths")
 	if len(values) == 0 {
 		return nil, nil
 	}
 	if len(values) > maxSelectedPaths {
-		return nil, errors.Errorf("failed to parse paths, should be less than %d", maxSelectedPaths)
-	}
-	filePaths := make([]string, len(s.r.Files))
-	for n, f := range s.r.Files {
-		filePaths[n] = strings.Join(f.Path, "/")
+		return nil, errBadRequest("failed to parse paths, should be less than %d", maxSelectedPaths)
 	}
-	base := strings.Trim(s.i.PathStr, "/")
+	idx := s.r.Index()
+	base := norm.NFC.String(strings.Trim(s.i.PathStr, "/"))
 	var res []string
 	encodedLen := 0
 	for _, v := range values {
 		if strings.ContainsRune(v, 0) {
-			return nil, errors.Errorf("failed to parse paths, NUL byte in %q", v)
+			return nil, errBadRequest("failed to parse paths, NUL byte in %q", v)
 		}
 		p := strings.Trim(v, "/")
 		if p == "" {
 			continue
 		}
-		if base != "" && p != base && !strings.HasPrefix(p, base+"/") {
-			return nil, errors.Errorf("failed to parse paths, %q is outside of exported directory %q", v, s.i.PathStr)
-		}
-		matched := false
-		prefix := p + "/"
-		for _, fp := range filePaths {
-			if fp == p || strings.HasPrefix(fp, prefix) {
-				matched = true
-				break
-			}
+		if np := norm.NFC.String(p); base != "" && np != base && !strings.HasPrefix(np, base+"/") {
+			return nil, errBadRequest("failed to parse paths, %q is outside of exported directory %q", v, s.i.PathStr)
 		}
-		if !matched {
-			return nil, errors.Errorf("path %q not found in resource", v)
+		// Matched regardless of Unicode normalization form, but passed on
+		// in the torrent's own form.
+		it, ok := idx.Path(p)
+		if !ok {
+			return nil, errNotFound("path %q not found in resource", v)
 		}
+		p = strings.Trim(it.PathStr, "/")
 		encodedLen += len(url.QueryEscape(p)) + len("&paths=")
 		if encodedLen > maxSelectedPathsEncodedLen {
-			return nil, errors.Errorf("failed to parse paths, encoded length should be less than %d", maxSelectedPathsEncodedLen)
+			return nil, errBadRequest("failed to parse paths, encoded length should be less than %d", maxSelectedPathsEncodedLen)
 		}
 		res = append(res, p)
 	}
@@ -480,7 +559,7 @@ func (s *StreamURLBuilder) Build() (u *MyURL, err error) {
 	if err != nil {
 		return
 	}
-	u, err = s.BuildStreamURL(u, "/index.m3u8")
+	u, err = s.BuildStreamURL(u, "/"+s.format.Manifest())
 	if err != nil {
 		return
 	}
@@ -491,7 +570,8 @@ func (s *StreamURLBuilder) BuildTranscodeURL(i *MyURL, suffix string) (u *MyURL,
 	u = i
 	u.Path += ServiceSeparator + string(ServiceTypeTranscode) + suffix
 	u.transcode = true
-	cached, err := s.cm.Get(u)
+	u.streamFormat = s.format
+	cached, err := s.cm.Get(requestContext(s.g), u)
 	if err != nil {
 		return nil, err
 	}
@@ -501,7 +581,8 @@ func (s *StreamURLBuilder) BuildTranscodeURL(i *MyURL, suffix string) (u *MyURL,
 
 func (s *StreamURLBuilder) BuildVODURL(i *MyURL, suffix string) (u *MyURL) {
 	u = i
-	u.Path += ServiceSeparator + string(ServiceTypeVOD) + "/hls/" + fmt.Sprintf("%x", sha1.Sum([]byte(s.r.ID+s.i.ID))) + suffix
+	u.Path += ServiceSeparator + string(ServiceTypeVOD) + "/" + string(s.format) + "/" + fmt.Sprintf("%x", sha1.Sum([]byte(s.r.ID+s.i.ID))) + suffix
+	u.streamFormat = s.format
 	return u
 }
 
@@ -525,16 +606,43 @@ func (s *StreamURLBuilder) BuildAudioStreamURL(i *MyURL, suffix string) (u *MyUR
 	return
 }
 
-func (s *StreamURLBuilder) BuildSRT2VTTURL(i *MyURL) (u *MyURL) {
+// vttConvertExt are the text subtitle formats the vtt service converts to
+// WebVTT.
+var vttConvertExt = []string{"srt", "ass", "ssa", "sub"}
+
+// isVobSub reports whether a .sub file is the bitmap half of a VobSub
+// sub/idx pair rather than a MicroDVD text subtitle.
+func isVobSub(r *Resource, i *ListItem) bool {
+	if i.Ext != "sub" {
+		return false
+	}
+	base := strings.TrimSuffix(i.PathStr, filepath.Ext(i.PathStr))
+	for _, ext := range []string{".idx", ".IDX"} {
+		if _, ok := r.Index().Path(base + ext); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// canConvertToVTT reports whether the subtitle has a WebVTT stream url.
+// VobSub subtitles are bitmaps and are streamed as is.
+func canConvertToVTT(r *Resource, i *ListItem) bool {
+	return slices.Contains(vttConvertExt, i.Ext) && !isVobSub(r, i)
+}
+
+func (s *StreamURLBuilder) BuildVTTURL(i *MyURL) (u *MyURL) {
 	u = i
-	l := strings.TrimSuffix(s.GetLastName(), "srt") + "vtt"
+	n := s.GetLastName()
+	l := strings.TrimSuffix(n, filepath.Ext(n)) + ".vtt"
 	u.Path += ServiceSeparator + string(ServiceTypeSRT2VTT) + "/" + l
 	return u
 }
 
 func (s *StreamURLBuilder) BuildSubtitleStreamURL(i *MyURL) (u *MyURL, err error) {
-	if s.i.Ext == "srt" {
-		u = s.BuildSRT2VTTURL(i)
+	u = i
+	if canConvertToVTT(s.r, s.i) {
+		u = s.BuildVTTURL(i)
 	}
 	return
 }
@@ -638,3 +746,54 @@ func (s *MediaProbeURLBuilder) Build() (u *MyURL, err error) {
 	}
 	return
 }
+
+// previewDefaultAt is the position in seconds of the video frame taken as
+// thumbnail, past the opening black frames and logos of most videos.
+const previewDefaultAt = 30
+
+// BuildPreviewURL points to the preview service: a video frame at
+// preview-at seconds, or the image itself, resized to preview-width pixels.
+func (s *PreviewURLBuilder) BuildPreviewURL(i *MyURL) (u *MyURL, err error) {
+	u = i
+	n := s.GetLastName()
+	q := u.Query()
+	if s.i.MediaFormat == Video {
+		n = strings.TrimSuffix(n, filepath.Ext(n)) + ".jpg"
+		at := previewDefaultAt
+		if v := s.g.Query("preview-at"); v != "" {
+			at, err = strconv.Atoi(v)
+			if err != nil || at < 0 {
+				return nil, errBadRequest("failed to parse preview-at, should be seconds")
+			}
+		}
+		q.Set("t", strconv.Itoa(at))
+	}
+	if v := s.g.Query("preview-width"); v != "" {
+		w, werr := strconv.Atoi(v)
+		if werr != nil || w <= 0 {
+			return nil, errBadRequest("failed to parse preview-width, should be pixels")
+		}
+		q.Set("w", strconv.Itoa(w))
+	}
+	u.Path += ServiceSeparator + string(ServiceTypePreview) + "/" + n
+	u.RawQuery = q.Encode()
+	return u, nil
+}
+
+func (s *PreviewURLBuilder) Build() (u *MyURL, err error) {
+	u = &MyURL{}
+	u, err = s.BuildScheme(u)
+	if err != nil {
+		return
+	}
+	u, err = s.BuildDomain(u)
+	if err != nil {
+		return
+	}
+	u, err = s.BuildBaseURL(u)
+	if err != nil {
+		return
+	}
+	u, err = s.BuildPreviewURL(u)
+	return
+}
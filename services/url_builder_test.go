@@ -0,0 +1,123 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func testTokenClaims(t *testing.T, u *MyURL, secret string) jwt.MapClaims {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(u.Query().Get("token"), claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	})
+	assert.Nil(t, err)
+	return claims
+}
+
+func TestURLBuilder_scopedToken(t *testing.T) {
+	tb, r := testTagBuilder(t)
+	ub := tb.ub
+	ub.tk = testTokens("secret", time.Hour)
+	ub.tk.bindIP = true
+	gin.SetMode(gin.TestMode)
+	g, _ := gin.CreateTestContext(httptest.NewRecorder())
+	g.Request = httptest.NewRequest(http.MethodGet, "/?user-id=u1", nil)
+	g.Request.RemoteAddr = "10.0.0.1:1234"
+	p := newSharedTokenParams(g)
+
+	i, _ := r.Index().Path("/Movie/movie.mp4")
+	u, err := ub.Build(r, &i, p, ExportTypeDownload)
+	if !assert.Nil(t, err) {
+		return
+	}
+	claims := testTokenClaims(t, u, "secret")
+	assert.Equal(t, "free", claims["role"])
+	assert.Equal(t, r.ID, claims["hash"])
+	assert.Equal(t, "/Movie/movie.mp4", claims["path"])
+	assert.Equal(t, "u1", claims["user_id"])
+	assert.Equal(t, "10.0.0.1", claims["ip"])
+	assert.Equal(t, float64(u.expiresAt.Unix()), claims["exp"])
+	assert.WithinDuration(t, time.Now().Add(time.Hour), u.expiresAt, 2*time.Second)
+
+	// Urls of one request expire together, whatever content they are for.
+	other, _ := r.Index().Path("/Movie/track.mp3")
+	u2, err := ub.Build(r, &other, p, ExportTypeDownload)
+	if assert.Nil(t, err) {
+		assert.Equal(t, u.expiresAt, u2.expiresAt)
+		assert.Equal(t, "/Movie/track.mp3", testTokenClaims(t, u2, "secret")["path"])
+	}
+
+	res, err := NewExport(NewDownloadExporter(ub)).Get(r, &i, &ExportGetArgs{Types: []ExportType{ExportTypeDownload}}, p)
+	if assert.Nil(t, err) && assert.NotNil(t, res.ExpiresAt) {
+		assert.Equal(t, u.expiresAt, *res.ExpiresAt)
+	}
+}
+
+func TestURLBuilder_requestTokenScope(t *testing.T) {
+	tb, r := testTagBuilder(t)
+	ub := tb.ub
+	ub.tk = testTokens("secret", time.Hour)
+	sign := func(claims jwt.MapClaims) string {
+		s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		return s
+	}
+	i, _ := r.Index().Path("/Movie/movie.mp4")
+
+	// Minted tokens keep the request claims and never outlive the request
+	// token.
+	exp := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	u, err := ub.Build(r, &i, testParams{"token": {sign(jwt.MapClaims{"role": "paid", "exp": exp.Unix(), "path": "/Movie"})}}, ExportTypeDownload)
+	if assert.Nil(t, err) {
+		claims := testTokenClaims(t, u, "secret")
+		assert.Equal(t, "paid", claims["role"])
+		assert.Equal(t, "/Movie/movie.mp4", claims["path"])
+		assert.Equal(t, exp, u.expiresAt)
+	}
+
+	_, err = ub.Build(r, &i, testParams{"token": {sign(jwt.MapClaims{"path": "/Other"})}}, ExportTypeDownload)
+	assert.Equal(t, ErrorCodeForbidden, ErrorCodeOf(err))
+	_, err = ub.Build(r, &i, testParams{"token": {sign(jwt.MapClaims{"hash": "0000000000000000000000000000000000000000"})}}, ExportTypeDownload)
+	assert.Equal(t, ErrorCodeForbidden, ErrorCodeOf(err))
+
+	// Without the api secret, the request token is passed on as is.
+	ub.tk = testTokens("", time.Hour)
+	ub.useSubdomains = false
+	u, err = ub.Build(r, &i, testParams{"token": {"opaque"}}, ExportTypeDownload)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "opaque", u.Query().Get("token"))
+		assert.True(t, u.expiresAt.IsZero())
+	}
+}
+
+func TestURLBuilder_tokenRole(t *testing.T) {
+	tb, r := testTagBuilder(t)
+	ub := tb.ub
+	ub.tk = testTokens("secret", 0)
+	ub.premiumDomain = "https://premium.example.com"
+	i, _ := r.Index().Path("/Movie/movie.mp4")
+	paid, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"role": "paid"}).SignedString([]byte("secret"))
+
+	u, err := ub.Build(r, &i, testParams{"token": {paid}}, ExportTypeDownload)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "premium.example.com", u.Host)
+		assert.Equal(t, "paid", u.BuildExportMeta().Role)
+	}
+
+	// A broken token gets free urls, and says so.
+	u, err = ub.Build(r, &i, testParams{"token": {paid[:len(paid)-2]}}, ExportTypeDownload)
+	if assert.Nil(t, err) {
+		assert.NotEqual(t, "premium.example.com", u.Host)
+		assert.Equal(t, "free", u.BuildExportMeta().Role)
+	}
+
+	ub.tk.strict = true
+	_, err = ub.Build(r, &i, testParams{"token": {paid[:len(paid)-2]}}, ExportTypeDownload)
+	assert.Equal(t, ErrorCodeInvalidToken, ErrorCodeOf(err))
+	assert.Equal(t, http.StatusUnauthorized, errorStatus(err))
+}
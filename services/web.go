@@ -1,6 +1,8 @@
 package services
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
@@ -8,6 +10,8 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -29,8 +33,9 @@ import (
 // @contact.email  support@webtor.io
 
 const (
-	webHostFlag = "host"
-	webPortFlag = "port"
+	webHostFlag           = "host"
+	webPortFlag           = "port"
+	webTrustedProxiesFlag = "trusted-proxies"
 )
 
 type Web struct {
@@ -38,19 +43,39 @@ type Web struct {
 	port int
 	ln   net.Listener
 	rm   *ResourceMap
+	rj   *ResourceJobs
 	c    *List
 	e    *Export
 	st   *SpeedTest
+	auth *Auth
+	tk   *Tokens
+	rl   *RateLimiter
+
+	// trustedProxies are the proxies whose X-Forwarded-For is trusted for
+	// the client ip, none if empty.
+	trustedProxies []string
 }
 
-func NewWeb(c *cli.Context, rm *ResourceMap, co *List, ex *Export, st *SpeedTest) *Web {
+func NewWeb(c *cli.Context, rm *ResourceMap, rj *ResourceJobs, co *List, ex *Export, st *SpeedTest, au *Auth, tk *Tokens, rl *RateLimiter) *Web {
+	var proxies []string
+	for _, p := range strings.Split(c.String(webTrustedProxiesFlag), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
 	return &Web{
 		host: c.String(webHostFlag),
 		port: c.Int(webPortFlag),
 		rm:   rm,
+		rj:   rj,
 		c:    co,
 		e:    ex,
 		st:   st,
+		auth: au,
+		tk:   tk,
+		rl:   rl,
+
+		trustedProxies: proxies,
 	}
 }
 
@@ -68,23 +93,34 @@ func RegisterWebFlags(f []cli.Flag) []cli.Flag {
 			Value:  8080,
 			EnvVar: "WEB_PORT",
 		},
+		cli.StringFlag{
+			Name:   webTrustedProxiesFlag,
+			Usage:  "comma-separated ips or cidrs of the proxies trusted to set X-Forwarded-For, none if empty",
+			Value:  "",
+			EnvVar: "WEB_TRUSTED_PROXIES",
+		},
 	)
 }
 
 // @Summary Stores resource
 // @Description Receives torrent or magnet-uri in request body.
 // @Description If magnet-uri provided instead of torrent, then it tries to fetch torrent from BitTorrent network (timeout 3 minutes).
+// @Description With async=true it returns 202 right away with a job to poll at /resource/jobs/{job_id}.
+// @Param async query bool false "resolve in background"
 // @Param resource body string true "resource" example("magnet:?xt=urn:btih:08ada5a7a6183aae1e09d831df6748d566095a10&dn=Sintel&tr=udp%3A%2F%2Ftracker.leechers-paradise.org%3A6969&tr=udp%3A%2F%2Ftracker.coppersurfer.tk%3A6969&tr=udp%3A%2F%2Ftracker.opentrackr.org%3A1337&tr=udp%3A%2F%2Fexplodie.org%3A6969&tr=udp%3A%2F%2Ftracker.empire-js.us%3A1337&tr=wss%3A%2F%2Ftracker.btorrent.xyz&tr=wss%3A%2F%2Ftracker.openwebtorrent.com&tr=wss%3A%2F%2Ftracker.fastcast.nz&ws=https%3A%2F%2Fwebtorrent.io%2Ftorrents%2F")
 // @Schemes
 // @Tags   resource
 // @Accept */*
 // @Produce json
 // @Success 200 {object} ResourceResponse
+// @Success 202 {object} ResourceJobResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 403 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
-// @Failure 408 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Failure 504 {object} ErrorResponse
 // @Router /resource/ [post]
 func (s *Web) postResource(g *gin.Context) {
 	b := g.Request.Body
@@ -94,18 +130,227 @@ func (s *Web) postResource(g *gin.Context) {
 		g.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	if g.Query("async") == "true" {
+		j, err := s.rj.Start(bb)
+		if err != nil {
+			g.Error(err)
+			return
+		}
+		g.Header("Location", "/resource/jobs/"+j.ID)
+		g.PureJSON(http.StatusAccepted, s.buildResourceJobResponse(j))
+		return
+	}
 	r, err := s.rm.Get(g.Request.Context(), bb)
 	if err != nil {
 		g.Error(err)
 		return
 	}
+	g.PureJSON(http.StatusOK, s.buildResourceResponse(r))
+}
+
+func (s *Web) buildResourceResponse(r *Resource) *ResourceResponse {
 	rr := &ResourceResponse{
 		ID:        r.ID,
 		Name:      r.Name,
 		MagnetURI: r.MagnetURI,
 	}
 	s.fillResourceStructure(rr, r)
-	g.PureJSON(http.StatusOK, rr)
+	return rr
+}
+
+const (
+	// maxBatchResources bounds a single POST /resource/batch call; larger
+	// imports should be split client-side.
+	maxBatchResources = 1000
+	// batchConcurrency caps how many items of one batch are resolved at the
+	// same time, so a single importer can't occupy the whole ResourceMap
+	// worker pool.
+	batchConcurrency = 20
+)
+
+// decodeBatchResource turns one batch entry into the payload accepted by
+// ResourceMap.Get: magnet-uris and infohashes are passed as is, anything
+// else is treated as a base64-encoded .torrent.
+func decodeBatchResource(v string) ([]byte, error) {
+	v = strings.TrimSpace(v)
+	if strings.HasPrefix(v, "magnet:") {
+		return []byte(v), nil
+	}
+	if sha1R.MatchString(strings.ToLower(v)) {
+		return []byte(strings.ToLower(v)), nil
+	}
+	b, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, errBadRequest("failed to parse resource, should be magnet-uri, infohash or base64-encoded torrent")
+	}
+	return b, nil
+}
+
+// @Summary Stores multiple resources
+// @Description Receives JSON array of magnet-uris, infohashes and base64-encoded torrents.
+// @Description Every item is resolved concurrently, the response keeps the order of the request.
+// @Description Each item carries the status code it would get from POST /resource/.
+// @Param resources body []string true "resources"
+// @Schemes
+// @Tags   resource
+// @Accept json
+// @Produce json
+// @Success 200 {array} BatchResourceItem
+// @Failure 400 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /resource/batch [post]
+func (s *Web) postResourceBatch(g *gin.Context) {
+	var values []string
+	if err := g.ShouldBindJSON(&values); err != nil {
+		g.Error(wrapBadRequest(err, "failed to parse resources, should be JSON array of strings"))
+		return
+	}
+	if len(values) > maxBatchResources {
+		g.Error(errBadRequest("failed to parse resources, should be less than %d", maxBatchResources))
+		return
+	}
+	if err := s.takeRate(g, RateBudgetCreate, max(len(values), 1)); err != nil {
+		g.Error(err)
+		return
+	}
+	ctx := g.Request.Context()
+	res := make([]BatchResourceItem, len(values))
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for n, v := range values {
+		wg.Add(1)
+		go func(n int, v string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			res[n] = s.getBatchResource(ctx, v)
+		}(n, v)
+	}
+	wg.Wait()
+	g.PureJSON(http.StatusOK, res)
+}
+
+func (s *Web) getBatchResource(ctx context.Context, v string) BatchResourceItem {
+	b, err := decodeBatchResource(v)
+	if err == nil {
+		var r *Resource
+		r, err = s.rm.Get(ctx, b)
+		if err == nil {
+			return BatchResourceItem{
+				Status:   http.StatusOK,
+				Resource: s.buildResourceResponse(r),
+			}
+		}
+	}
+	log.WithError(err).Warn("failed to get batch resource")
+	return BatchResourceItem{
+		Status: errorStatus(err),
+		Error:  newErrorResponse(err),
+	}
+}
+
+// @Summary Returns resource job
+// @Description Receives job id returned by POST /resource/?async=true and reports its state.
+// @Description Job id is the infohash of the submitted resource.
+// @Schemes
+// @Param job_id path string true "job_id" example("08ada5a7a6183aae1e09d831df6748d566095a10")
+// @Tags  resource
+// @Accept */*
+// @Produce json
+// @Success 200 {object} ResourceJobResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /resource/jobs/{job_id} [get]
+func (s *Web) getResourceJob(g *gin.Context) {
+	j, err := s.rj.Get(strings.ToLower(g.Param("job_id")))
+	if err != nil {
+		g.Error(err)
+		return
+	}
+	g.PureJSON(http.StatusOK, s.buildResourceJobResponse(j))
+}
+
+// resolveEventsKeepAlive is the interval of SSE comments sent while a stage
+// lasts, so proxies don't drop a stream idling on a slow metadata fetch.
+const resolveEventsKeepAlive = 15 * time.Second
+
+// @Summary Streams resource resolution progress
+// @Description Server-Sent Events stream following resolution of the resource: joins the job
+// @Description started with POST /resource/?async=true, or resolves the resource from the store.
+// @Description Events are named after the stage (queued, fetching_metadata, pushed, ready, failed).
+// @Description The stream ends with a ready event carrying ResourceResponse or a failed event carrying ErrorResponse.
+// @Schemes
+// @Param resource_id path string true "resource_id" example("08ada5a7a6183aae1e09d831df6748d566095a10")
+// @Tags  resource
+// @Accept */*
+// @Produce text/event-stream
+// @Success 200 {object} ResourceEvent
+// @Failure 400 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /resource/{resource_id}/resolve/events [get]
+func (s *Web) getResolveEvents(g *gin.Context) {
+	id := strings.ToLower(g.Param("resource_id"))
+	if !sha1R.MatchString(id) {
+		g.Error(errBadRequest("failed to parse resource id %v", id))
+		return
+	}
+	j, err := s.rj.Start([]byte(id))
+	if err != nil {
+		g.Error(err)
+		return
+	}
+	g.Header("Cache-Control", "no-cache")
+	g.Header("X-Accel-Buffering", "no")
+	ka := time.NewTicker(resolveEventsKeepAlive)
+	defer ka.Stop()
+	sent := 0
+	g.Stream(func(w io.Writer) bool {
+		stages, changed := j.Stages(sent)
+		for _, st := range stages {
+			sent++
+			switch st {
+			case ResourceStageReady:
+				_, r, _ := j.State()
+				g.SSEvent(string(st), s.buildResourceResponse(r))
+				return false
+			case ResourceStageFailed:
+				_, _, err := j.State()
+				g.SSEvent(string(st), newErrorResponse(err))
+				return false
+			default:
+				g.SSEvent(string(st), &ResourceEvent{ID: j.ID, Stage: st})
+			}
+		}
+		if len(stages) > 0 {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-ka.C:
+			_, _ = io.WriteString(w, ": keep-alive\n\n")
+		case <-g.Request.Context().Done():
+			return false
+		}
+		return true
+	})
+}
+
+func (s *Web) buildResourceJobResponse(j *ResourceJob) *ResourceJobResponse {
+	st, r, err := j.State()
+	jr := &ResourceJobResponse{
+		ID:     j.ID,
+		Status: st,
+	}
+	if r != nil {
+		jr.Resource = s.buildResourceResponse(r)
+	}
+	if err != nil {
+		jr.Error = newErrorResponse(err)
+	}
+	return jr
 }
 
 // @Summary Returns resource
@@ -118,6 +363,7 @@ func (s *Web) postResource(g *gin.Context) {
 // @Success 200 {object} ResourceResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /resource/{resource_id} [get]
 func (s *Web) getResource(g *gin.Context) {
@@ -183,6 +429,7 @@ func magnetFromInfoHash(id, name string) string {
 // @Success 200 {object} ResourceResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /resource/{resource_id}.torrent [get]
 func (s *Web) getTorrent(g *gin.Context) {
@@ -199,12 +446,17 @@ func (s *Web) getTorrent(g *gin.Context) {
 // @Summary Lists resource
 // @Description Lists files and directories of specific resource.
 // @Description All ids in response can be used for export.
-// @Param resource_id path  string true  "resource_id" example("08ada5a7a6183aae1e09d831df6748d566095a10")
-// @Param path        query string false "path"
-// @Param limit       query int    false "limit"
-// @Param offset      query int    false "offset"
-// @Param output      query string false "output" Enums(list, tree)
-// @Param sort        query string false "sort" Enums(name, size) default(name)
+// @Param resource_id  path  string   true  "resource_id" example("08ada5a7a6183aae1e09d831df6748d566095a10")
+// @Param path         query string   false "directory path, matched regardless of Unicode normalization form"
+// @Param limit        query int      false "limit, up to 1000, or up to 10000 with cursor"
+// @Param offset       query int      false "offset"
+// @Param cursor       query string   false "next_cursor of the previous page, must be used with the same output, sort, order, path and filters"
+// @Param output       query string   false "output" Enums(list, tree)
+// @Param sort         query string   false "sort, folders go first; name is natural (Episode 2 before Episode 10), index keeps torrent order" Enums(name, size, index, ext, media_format)
+// @Param order        query string   false "sort order, asc by default except for size" Enums(asc, desc)
+// @Param q            query string   false "filter files by name, case-insensitive substring or glob (*, ?, [...])"
+// @Param media_format query []string false "filter files by media format, comma-separated" Enums(video, audio, image, subtitle)
+// @Param ext          query []string false "filter files by extension, comma-separated"
 // @Schemes
 // @Tags   list
 // @Accept */*
@@ -212,6 +464,7 @@ func (s *Web) getTorrent(g *gin.Context) {
 // @Success 200 {object} ListResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /resource/{resource_id}/list [get]
 func (s *Web) getList(g *gin.Context) {
@@ -234,13 +487,116 @@ func (s *Web) getList(g *gin.Context) {
 	g.PureJSON(http.StatusOK, cr)
 }
 
+// getResourceContent loads the manifest of the resource and resolves the
+// content_id in it. content_id is either the SHA1 of the content path, looked
+// up in the directory index, or a file index in the torrent's natural order.
+// Routes without content_id select the content by the path query param.
+func (s *Web) getResourceContent(g *gin.Context) (*Resource, *ListItem, error) {
+	contentID := strings.ToLower(g.Param("content_id"))
+	resourceID := strings.ToLower(g.Param("resource_id"))
+	r, err := s.rm.GetManifest(g.Request.Context(), resourceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if contentID == "" {
+		p := g.Query("path")
+		if p == "" {
+			return nil, nil, errBadRequest("failed to parse path, should not be empty")
+		}
+		it, ok := r.Index().Path(p)
+		if !ok {
+			return nil, nil, errNotFound("content with path %v not found", p)
+		}
+		return r, &it, nil
+	}
+	it, err := lookupContent(r, contentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, it, nil
+}
+
+// lookupContent resolves a lowercased content_id in the directory index of r.
+func lookupContent(r *Resource, contentID string) (*ListItem, error) {
+	if idx, ierr := strconv.Atoi(contentID); ierr == nil {
+		// content_id is a file index into the torrent's natural file order.
+		// Lets clients (Stremio addon) skip the /list round-trip when they
+		// already know which file in the torrent they want.
+		it, ok := r.Index().File(idx)
+		if !ok {
+			return nil, errNotFound("file idx %d out of range (resource has %d files)", idx, len(r.Files))
+		}
+		return &it, nil
+	}
+	if !sha1R.MatchString(contentID) {
+		return nil, errBadRequest("failed to parse content id %v", contentID)
+	}
+	it, ok := r.Index().Item(contentID)
+	if !ok {
+		return nil, errNotFound("content with id %v not found", contentID)
+	}
+	return &it, nil
+}
+
+// @Summary Returns resource content
+// @Description Returns the file or directory item by content_id, with size
+// @Description and files count for directories. No urls are built, see
+// @Description export for that. content_id is either the SHA1 of the
+// @Description content path (returned by /list) or the file's index in the
+// @Description torrent's natural file order.
+// @Param resource_id path  string true  "resource_id" example("08ada5a7a6183aae1e09d831df6748d566095a10")
+// @Param content_id  path  string true  "content_id"  example("ca2453df3e7691c28934eebed5a253ee0aabd29f")
+// @Schemes
+// @Tags list
+// @Accept */*
+// @Produce json
+// @Success 200 {object} ListItem
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /resource/{resource_id}/content/{content_id} [get]
+func (s *Web) getContent(g *gin.Context) {
+	_, item, err := s.getResourceContent(g)
+	if err != nil {
+		g.Error(err)
+		return
+	}
+	g.PureJSON(http.StatusOK, item)
+}
+
+// @Summary Returns resource content by path
+// @Description Same as /content/{content_id}, with the content selected by
+// @Description its path inside the torrent. Unicode NFC and NFD forms of the
+// @Description path both match.
+// @Param resource_id path  string true  "resource_id" example("08ada5a7a6183aae1e09d831df6748d566095a10")
+// @Param path        query string true  "content path" example("/Sintel/Sintel.mp4")
+// @Schemes
+// @Tags list
+// @Accept */*
+// @Produce json
+// @Success 200 {object} ListItem
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /resource/{resource_id}/content [get]
+func (s *Web) getContentByPath(g *gin.Context) {
+	s.getContent(g)
+}
+
 // @Summary Exports resource content
 // @Description Provides url for exporting resource content. content_id is
 // @Description either the SHA1 of the file's path (returned by /list) or
 // @Description the file's index in the torrent's natural file order
 // @Description (matches the fileIdx convention used by Stremio addons).
-// @Param output         query string false "output"         Enums(download, stream, torrent_client_stat, subtitles, media_probe)
+// @Param output         query string false "output"         Enums(download, stream, torrent_client_stat, subtitles, media_probe, playlist, preview)
 // @Param archive-format query string false "archive format for directory downloads" Enums(zip, tar) default(zip)
+// @Param playlist-format query string false "playlist format of directories, with output=playlist" Enums(m3u8, xspf) default(m3u8)
+// @Param stream-format query string false "adaptive streaming format of stream urls, html tags carry both" Enums(hls, dash) default(hls)
+// @Param lang query string false "preferred subtitle languages, comma-separated, the default track is picked by it or by Accept-Language" example(en,fr)
+// @Param preview-at query int false "position of the video thumbnail in seconds" default(30)
+// @Param preview-width query int false "width of the preview in pixels"
 // @Param paths query []string false "limit directory archive to selected file/folder paths (repeatable)"
 // @Param resource_id path  string true  "resource_id" example("08ada5a7a6183aae1e09d831df6748d566095a10")
 // @Param content_id  path  string true  "content_id"  example("ca2453df3e7691c28934eebed5a253ee0aabd29f")
@@ -250,7 +606,9 @@ func (s *Web) getList(g *gin.Context) {
 // @Produce json
 // @Success 200 {object} ExportResponse
 // @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /resource/{resource_id}/export/{content_id} [get]
 func (s *Web) getExport(g *gin.Context) {
@@ -259,82 +617,239 @@ func (s *Web) getExport(g *gin.Context) {
 		g.Error(err)
 		return
 	}
-	contentID := strings.ToLower(g.Param("content_id"))
-	resourceID := strings.ToLower(g.Param("resource_id"))
-	r, err := s.rm.Get(g.Request.Context(), []byte(resourceID))
+	r, item, err := s.getResourceContent(g)
+	if err != nil {
+		g.Error(err)
+		return
+	}
+	res, err := s.e.Get(r, item, args, newSharedTokenParams(g))
 	if err != nil {
 		g.Error(err)
 		return
 	}
+	g.PureJSON(http.StatusOK, res)
+}
 
-	var item *ListItem
-	if idx, ierr := strconv.Atoi(contentID); ierr == nil {
-		// content_id is a file index into the torrent's natural file order.
-		// Lets clients (Stremio addon) skip the /list round-trip when they
-		// already know which file in the torrent they want.
-		if idx < 0 || idx >= len(r.Files) {
-			g.Error(errors.Errorf("file idx %d out of range (resource has %d files)", idx, len(r.Files)))
-			return
-		}
-		it := s.c.buildFile(r.Files[idx], idx)
-		item = &it
-	} else if sha1R.Match([]byte(contentID)) {
-		cr, lerr := s.c.Get(r, NewListGetArgs())
-		if lerr != nil {
-			g.Error(lerr)
-			return
-		}
-		for _, i := range cr.Items {
-			if i.ID == contentID {
-				item = &i
-				break
-			}
-		}
-		if item == nil && cr.ID == contentID {
-			item = &cr.ListItem
-		}
-	} else {
-		g.Error(errors.Errorf("failed to parse content id %v", contentID))
+// @Summary Exports resource content by path
+// @Description Same as /export/{content_id}, with the content selected by
+// @Description its path inside the torrent. Unicode NFC and NFD forms of the
+// @Description path both match.
+// @Param output         query string false "output"         Enums(download, stream, torrent_client_stat, subtitles, media_probe, playlist, preview)
+// @Param archive-format query string false "archive format for directory downloads" Enums(zip, tar) default(zip)
+// @Param playlist-format query string false "playlist format of directories, with output=playlist" Enums(m3u8, xspf) default(m3u8)
+// @Param stream-format query string false "adaptive streaming format of stream urls, html tags carry both" Enums(hls, dash) default(hls)
+// @Param lang query string false "preferred subtitle languages, comma-separated, the default track is picked by it or by Accept-Language" example(en,fr)
+// @Param preview-at query int false "position of the video thumbnail in seconds" default(30)
+// @Param preview-width query int false "width of the preview in pixels"
+// @Param paths query []string false "limit directory archive to selected file/folder paths (repeatable)"
+// @Param resource_id path  string true  "resource_id" example("08ada5a7a6183aae1e09d831df6748d566095a10")
+// @Param path        query string true  "content path" example("/Sintel/Sintel.mp4")
+// @Schemes
+// @Tags export
+// @Accept */*
+// @Produce json
+// @Success 200 {object} ExportResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /resource/{resource_id}/export [get]
+func (s *Web) getExportByPath(g *gin.Context) {
+	s.getExport(g)
+}
+
+// @Summary Returns playlist of directory
+// @Description Returns an M3U8 or XSPF playlist of every video and audio file
+// @Description under the directory, each entry pointing to the file's stream
+// @Description url. Meant to be opened right away in a player like VLC; the
+// @Description same body is available as the playlist export type.
+// @Param playlist-format query string false "playlist format" Enums(m3u8, xspf) default(m3u8)
+// @Param resource_id     path  string true  "resource_id" example("08ada5a7a6183aae1e09d831df6748d566095a10")
+// @Param content_id      path  string true  "content_id"  example("de1524300a82e6ec64511dd7ba9765dc85abeac0")
+// @Schemes
+// @Tags export
+// @Accept */*
+// @Produce audio/x-mpegurl,application/xspf+xml
+// @Success 200 {string} string
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /resource/{resource_id}/playlist/{content_id} [get]
+func (s *Web) getPlaylist(g *gin.Context) {
+	r, item, err := s.getResourceContent(g)
+	if err != nil {
+		g.Error(err)
+		return
+	}
+	if item.Type != ListTypeDirectory {
+		g.Error(errBadRequest("failed to build playlist, content %v is not a directory", item.ID))
+		return
+	}
+	res, err := s.e.Get(r, item, &ExportGetArgs{Types: []ExportType{ExportTypePlaylist}}, newSharedTokenParams(g))
+	if err != nil {
+		g.Error(err)
+		return
+	}
+	ei, ok := res.ExportItems[string(ExportTypePlaylist)]
+	if !ok || ei.Playlist == nil {
+		g.Error(errNotFound("no playable files in %v", item.PathStr))
 		return
 	}
+	name := item.Name
+	if name == "" {
+		name = r.Name
+	}
+	g.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", name+"."+string(ei.Playlist.Format)))
+	g.Data(http.StatusOK, ei.Playlist.MimeType, []byte(ei.Playlist.Body))
+}
 
-	if item == nil {
-		g.Error(errors.Errorf("content with id %v not found", contentID))
+// maxExportBatchItems bounds the number of items of POST /export, after
+// directories are expanded.
+const maxExportBatchItems = 1000
+
+// @Summary Exports multiple resource contents
+// @Description Provides urls for exporting several contents of one resource
+// @Description at once. The resource is loaded once, the request token is
+// @Description parsed once and cache probes are shared between items.
+// @Description With recursive=true a directory content_id expands to every
+// @Description file under it. Each item carries the status code it would get
+// @Description from GET /export/{content_id}, the response keeps the order of
+// @Description the request.
+// @Param types       query string            false "comma-separated export types" example(download,stream)
+// @Param stream-format query string          false "adaptive streaming format of stream urls" Enums(hls, dash) default(hls)
+// @Param resource_id path  string            true  "resource_id" example("08ada5a7a6183aae1e09d831df6748d566095a10")
+// @Param request     body  ExportBatchRequest true "content ids"
+// @Schemes
+// @Tags export
+// @Accept json
+// @Produce json
+// @Success 200 {array} ExportBatchItem
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /resource/{resource_id}/export [post]
+func (s *Web) postExport(g *gin.Context) {
+	args, err := ExportGetArgsFromParams(g)
+	if err != nil {
+		g.Error(err)
+		return
+	}
+	var req ExportBatchRequest
+	if err := g.ShouldBindJSON(&req); err != nil {
+		g.Error(wrapBadRequest(err, "failed to parse request, should be JSON object with content_ids"))
+		return
+	}
+	if len(req.ContentIDs) == 0 {
+		g.Error(errBadRequest("failed to parse content_ids, should not be empty"))
 		return
 	}
-	res, err := s.e.Get(r, item, args, g)
+	resourceID := strings.ToLower(g.Param("resource_id"))
+	r, err := s.rm.GetManifest(g.Request.Context(), resourceID)
 	if err != nil {
 		g.Error(err)
 		return
 	}
+	res := s.expandExportBatch(r, req)
+	if len(res) > maxExportBatchItems {
+		g.Error(errBadRequest("failed to parse content_ids, should select less than %d items", maxExportBatchItems))
+		return
+	}
+	// Items and the playlist entries of directories share one limit.
+	p := newSharedTokenParams(g)
+	var wg sync.WaitGroup
+	for n := range res {
+		if res[n].Error != nil {
+			continue
+		}
+		it := &res[n]
+		p.limiter.Go(&wg, func() {
+			ex, err := s.e.Get(r, &it.Export.Source, args, p)
+			if err != nil {
+				log.WithError(err).Warn("failed to export batch item")
+				it.Status = errorStatus(err)
+				it.Export = nil
+				it.Error = newErrorResponse(err)
+				return
+			}
+			it.Export = ex
+		})
+	}
+	wg.Wait()
 	g.PureJSON(http.StatusOK, res)
 }
 
+// expandExportBatch resolves the requested content ids into batch items,
+// expanding directories with req.Recursive. Resolved items carry the
+// content in Export.Source until exported; repeated contents are exported
+// once.
+func (s *Web) expandExportBatch(r *Resource, req ExportBatchRequest) []ExportBatchItem {
+	var res []ExportBatchItem
+	seen := map[string]struct{}{}
+	add := func(id string, it ListItem) {
+		if _, ok := seen[it.ID]; ok {
+			return
+		}
+		seen[it.ID] = struct{}{}
+		res = append(res, ExportBatchItem{
+			ID:     id,
+			Status: http.StatusOK,
+			Export: &ExportResponse{Source: it},
+		})
+	}
+	for _, v := range req.ContentIDs {
+		id := strings.ToLower(strings.TrimSpace(v))
+		it, err := lookupContent(r, id)
+		if err != nil {
+			res = append(res, ExportBatchItem{
+				ID:     id,
+				Status: errorStatus(err),
+				Error:  newErrorResponse(err),
+			})
+			continue
+		}
+		if it.Type == ListTypeDirectory && req.Recursive {
+			for _, f := range r.Index().Files(it.Path) {
+				add(f.ID, f)
+			}
+			continue
+		}
+		add(id, *it)
+	}
+	return res
+}
+
 func (s *Web) errorHandler(c *gin.Context) {
 	c.Next()
 	if len(c.Errors) == 0 {
 		return
 	}
-	err := c.Errors[0]
+	err := c.Errors[0].Err
 	log.Error(err)
+	c.PureJSON(errorStatus(err), newErrorResponse(err))
+}
 
-	status := http.StatusInternalServerError
+// limit returns the rate limiting middleware of budget b.
+func (s *Web) limit(b RateBudget) gin.HandlerFunc {
+	if s.rl == nil {
+		return func(g *gin.Context) {
+			g.Next()
+		}
+	}
+	return s.rl.Handler(b)
+}
 
-	if strings.Contains(err.Error(), "failed to parse") {
-		status = http.StatusBadRequest
-	} else if strings.Contains(err.Error(), "forbidden") {
-		status = http.StatusForbidden
-	} else if strings.Contains(err.Error(), "not found") {
-		status = http.StatusNotFound
-	} else if strings.Contains(err.Error(), "timeout") {
-		status = http.StatusRequestTimeout
-	} else if strings.Contains(err.Error(), "deadline exceeded") {
-		// Upstream ran out of time, not the client — 504, so consumers
-		// (RapidAPI et al.) can tell a transient dependency stall from a bug
-		// on our side and retry instead of filing a bug report.
-		status = http.StatusGatewayTimeout
+// takeRate counts n requests against budget b, for handlers that only
+// know what a request costs once they read it.
+func (s *Web) takeRate(g *gin.Context, b RateBudget, n int) error {
+	if s.rl == nil {
+		return nil
 	}
-	c.PureJSON(status, &ErrorResponse{Error: err.Error()})
+	return s.rl.Take(g, b, n)
 }
 
 func (s *Web) Serve() error {
@@ -345,18 +860,42 @@ func (s *Web) Serve() error {
 		return errors.Wrap(err, "Failed to web listen to tcp connection")
 	}
 	r := gin.Default()
+	// The client ip keys rate limits and binds tokens, so X-Forwarded-For
+	// only counts when it comes from a trusted proxy.
+	if err := r.SetTrustedProxies(s.trustedProxies); err != nil {
+		return errors.Wrap(err, "failed to set trusted proxies")
+	}
 	r.UseRawPath = true
+	r.ContextWithFallback = true
+	r.Use(tracingHandler)
+	r.Use(metricsHandler)
 	r.Use(s.errorHandler)
 	rg := r.Group("/resource")
+	if s.auth != nil {
+		rg.Use(s.auth.Handler)
+	}
 	{
-		rg.POST("/", s.postResource)
-		rg.GET("/:resource_id", s.getResource)
-		rg.GET("/:resource_id/list", s.getList)
-		rg.GET("/:resource_id/export/:content_id", s.getExport)
+		create := s.limit(RateBudgetCreate)
+		list := s.limit(RateBudgetList)
+		export := s.limit(RateBudgetExport)
+		rg.POST("/", create, s.postResource)
+		// Batches are counted per resource, see postResourceBatch.
+		rg.POST("/batch", s.postResourceBatch)
+		rg.GET("/jobs/:job_id", list, s.getResourceJob)
+		rg.GET("/:resource_id", list, s.getResource)
+		rg.GET("/:resource_id/list", list, s.getList)
+		rg.GET("/:resource_id/resolve/events", list, s.getResolveEvents)
+		rg.GET("/:resource_id/content", list, s.getContentByPath)
+		rg.GET("/:resource_id/content/:content_id", list, s.getContent)
+		rg.GET("/:resource_id/export", export, s.getExportByPath)
+		rg.POST("/:resource_id/export", export, s.postExport)
+		rg.GET("/:resource_id/export/:content_id", export, s.getExport)
+		rg.GET("/:resource_id/playlist/:content_id", export, s.getPlaylist)
 	}
 	if s.st != nil {
 		r.GET("/speedtest", s.getSpeedtest)
 	}
+	r.GET("/.well-known/jwks.json", s.getJWKS)
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	docs.SwaggerInfo.BasePath = "/"
@@ -364,8 +903,19 @@ func (s *Web) Serve() error {
 	return http.Serve(s.ln, r)
 }
 
+// @Summary Token public keys
+// @Description Returns the public keys of the RS256 and EdDSA keys that minted tokens are signed with,
+// @Description picked by the kid header of a token. HS256 keys are never published.
+// @Tags   tokens
+// @Produce json
+// @Success 200 {object} JWKS
+// @Router /.well-known/jwks.json [get]
+func (s *Web) getJWKS(g *gin.Context) {
+	g.PureJSON(http.StatusOK, s.tk.JWKS())
+}
+
 func (s *Web) getSpeedtest(g *gin.Context) {
-	urls, err := s.st.GetURLs(g)
+	urls, err := s.st.GetURLs(newSharedTokenParams(g))
 	if err != nil {
 		g.Error(err)
 		return
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tsp "github.com/webtor-io/torrent-store/proto"
+)
+
+func TestDecodeBatchResource(t *testing.T) {
+	assert := assert.New(t)
+
+	b, err := decodeBatchResource(sintelMagnet)
+	assert.Nil(err)
+	assert.Equal(sintelMagnet, string(b))
+
+	b, err = decodeBatchResource(" 08ADA5A7A6183AAE1E09D831DF6748D566095A10 ")
+	assert.Nil(err)
+	assert.Equal("08ada5a7a6183aae1e09d831df6748d566095a10", string(b))
+
+	b, err = decodeBatchResource(base64.StdEncoding.EncodeToString(loadSintel(t)))
+	assert.Nil(err)
+	assert.Equal(loadSintel(t), b)
+
+	_, err = decodeBatchResource("not base64 at all!")
+	assert.ErrorContains(err, "failed to parse")
+}
+
+// Each batch entry is resolved on its own: a missing infohash or a garbage
+// payload must not fail the entries next to it, and every entry reports the
+// status POST /resource/ would have answered with.
+func TestPostResourceBatch(t *testing.T) {
+	assert := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	rm := NewTestResourceMap()
+	tsclm, _ := rm.ts.Get()
+	tsclmm := tsclm.(*TorrentStoreClientMock)
+	tsclmm.On("Touch", mock.Anything, &tsp.TouchRequest{InfoHash: manifestHash}, mock.Anything).
+		Return(nil, nil)
+	tsclmm.On("Touch", mock.Anything, mock.Anything, mock.Anything).
+		Return(nil, status.Error(codes.NotFound, "not found"))
+	tsclmm.On("Push", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+
+	w := &Web{rm: rm, c: NewList()}
+	body, _ := json.Marshal([]string{
+		base64.StdEncoding.EncodeToString(loadSintel(t)),
+		"da39a3ee5e6b4b0d3255bfef95601890afd80709",
+		"junk",
+	})
+	rec := httptest.NewRecorder()
+	g, _ := gin.CreateTestContext(rec)
+	g.Request = httptest.NewRequest(http.MethodPost, "/resource/batch", bytes.NewReader(body))
+	w.postResourceBatch(g)
+
+	assert.Equal(http.StatusOK, rec.Code)
+	var res []BatchResourceItem
+	assert.Nil(json.Unmarshal(rec.Body.Bytes(), &res))
+	if assert.Len(res, 3) {
+		assert.Equal(http.StatusOK, res[0].Status)
+		if assert.NotNil(res[0].Resource) {
+			assert.Equal(manifestHash, res[0].Resource.ID)
+		}
+		assert.Equal(http.StatusNotFound, res[1].Status)
+		assert.NotNil(res[1].Error)
+		assert.Equal(http.StatusBadRequest, res[2].Status)
+		assert.NotNil(res[2].Error)
+	}
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	tsp "github.com/webtor-io/torrent-store/proto"
+)
+
+func TestWeb_getContent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rm := NewTestResourceMap()
+	tsclm, _ := rm.ts.Get()
+	tsclmm := tsclm.(*TorrentStoreClientMock)
+	tsclmm.On("Files", mock.Anything, mock.Anything, mock.Anything).Return(&tsp.FilesReply{
+		Name: "Sintel",
+		Files: []*tsp.FileInfo{
+			{Path: []string{"Sintel", "Sintel.de.srt"}, Length: 1652},
+			{Path: []string{"Sintel", "Sintel.mp4"}, Length: 129241752},
+		},
+	}, nil).Once()
+
+	w := &Web{rm: rm, c: NewList()}
+	r := gin.New()
+	r.Use(w.errorHandler)
+	r.GET("/resource/:resource_id/content", w.getContentByPath)
+	r.GET("/resource/:resource_id/content/:content_id", w.getContent)
+
+	get := func(contentID string) (*httptest.ResponseRecorder, ListItem) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/resource/"+manifestHash+"/content/"+contentID, nil))
+		var i ListItem
+		_ = json.Unmarshal(rec.Body.Bytes(), &i)
+		return rec, i
+	}
+
+	// SHA1 of "/Sintel"
+	rec, dir := get("de1524300a82e6ec64511dd7ba9765dc85abeac0")
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.Equal(t, ListTypeDirectory, dir.Type)
+		assert.Equal(t, "/Sintel", dir.PathStr)
+		assert.EqualValues(t, 129243404, dir.Size)
+		assert.Equal(t, 2, dir.FilesCount)
+	}
+
+	rec, file := get("1")
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.Equal(t, "Sintel.mp4", file.Name)
+		assert.Equal(t, Video, file.MediaFormat)
+		assert.Equal(t, 1, file.Index)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/resource/"+manifestHash+"/content?path=%2FSintel%2FSintel.de.srt", nil))
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.Contains(t, rec.Body.String(), `"name":"Sintel.de.srt"`)
+	}
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/resource/"+manifestHash+"/content?path=%2FSintel%2Fmissing.srt", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/resource/"+manifestHash+"/content", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, _ = get("2")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec, _ = get("ffffffffffffffffffffffffffffffffffffffff")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec, _ = get("not-an-id")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// The manifest is loaded once and shared by all lookups.
+	tsclmm.AssertExpectations(t)
+}
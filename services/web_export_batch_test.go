@@ -0,0 +1,140 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	tsp "github.com/webtor-io/torrent-store/proto"
+)
+
+type testExporter struct {
+	mux sync.Mutex
+	ids []string
+}
+
+func (s *testExporter) Type() ExportType {
+	return ExportTypeDownload
+}
+
+func (s *testExporter) Export(_ *Resource, i *ListItem, _ ParamGetter) (*ExportItem, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.ids = append(s.ids, i.ID)
+	return &ExportItem{Type: string(ExportTypeDownload), URL: "http://example.com" + i.PathStr}, nil
+}
+
+func TestWeb_postExport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rm := NewTestResourceMap()
+	tsclm, _ := rm.ts.Get()
+	tsclmm := tsclm.(*TorrentStoreClientMock)
+	tsclmm.On("Files", mock.Anything, mock.Anything, mock.Anything).Return(&tsp.FilesReply{
+		Name: "Show",
+		Files: []*tsp.FileInfo{
+			{Path: []string{"Show", "Season 1", "e01.mkv"}, Length: 10},
+			{Path: []string{"Show", "Season 1", "e02.mkv"}, Length: 20},
+			{Path: []string{"Show", "Season 2", "e01.mkv"}, Length: 30},
+		},
+	}, nil).Once()
+
+	ex := &testExporter{}
+	w := &Web{rm: rm, c: NewList(), e: NewExport(ex)}
+	r := gin.New()
+	r.Use(w.errorHandler)
+	r.POST("/resource/:resource_id/export", w.postExport)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/resource/"+manifestHash+"/export?types=download", strings.NewReader(body)))
+		return rec
+	}
+
+	season1 := buildRootItem([]string{"Show", "Season 1"}, 0).ID
+	rec := post(`{"content_ids":["` + season1 + `","2","1","ffffffffffffffffffffffffffffffffffffffff"],"recursive":true}`)
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+	var res []ExportBatchItem
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	if assert.Len(t, res, 4) {
+		assert.Equal(t, "/Show/Season 1/e01.mkv", res[0].Export.Source.PathStr)
+		assert.Equal(t, "/Show/Season 1/e02.mkv", res[1].Export.Source.PathStr)
+		assert.Equal(t, "2", res[2].ID)
+		assert.Equal(t, "/Show/Season 2/e01.mkv", res[2].Export.Source.PathStr)
+		assert.Equal(t, "http://example.com/Show/Season 2/e01.mkv", res[2].Export.ExportItems["download"].URL)
+		assert.Equal(t, http.StatusNotFound, res[3].Status)
+		assert.Nil(t, res[3].Export)
+		assert.Equal(t, ErrorCodeNotFound, res[3].Error.Code)
+	}
+	// Content 1 was already expanded from Season 1.
+	assert.Len(t, ex.ids, 3)
+
+	rec = post(`{"content_ids":[]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = post(`not json`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	tsclmm.AssertExpectations(t)
+}
+
+func TestSharedTokenParams_parsesTokenOnce(t *testing.T) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"role": "paid"}).SignedString([]byte("secret"))
+	assert.Nil(t, err)
+	g, _ := gin.CreateTestContext(httptest.NewRecorder())
+	g.Request = httptest.NewRequest(http.MethodPost, "/?token="+token, nil)
+	p := newSharedTokenParams(g)
+
+	b1 := &BaseURLBuilder{g: p, tk: testTokens("secret", 0)}
+	role, err := b1.getRole()
+	assert.Nil(t, err)
+	assert.Equal(t, "paid", role)
+
+	// A later builder of the same request reuses the parsed claims even if
+	// it could not parse the token itself.
+	b2 := &BaseURLBuilder{g: p, tk: testTokens("other", 0)}
+	role, err = b2.getRole()
+	assert.Nil(t, err)
+	assert.Equal(t, "paid", role)
+}
+
+// Nested fan-outs of a request share one limit and never wait on each
+// other.
+func TestFanOut_nested(t *testing.T) {
+	l := make(fanOut, 3)
+	var mux sync.Mutex
+	running, peak := 0, 0
+	work := func() {
+		mux.Lock()
+		running++
+		peak = max(peak, running)
+		mux.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mux.Lock()
+		running--
+		mux.Unlock()
+	}
+	var wg sync.WaitGroup
+	for range 10 {
+		l.Go(&wg, func() {
+			var inner sync.WaitGroup
+			for range 10 {
+				l.Go(&inner, work)
+			}
+			inner.Wait()
+		})
+	}
+	wg.Wait()
+	// The limit, plus the caller running what is over it.
+	assert.LessOrEqual(t, peak, 4)
+	assert.Empty(t, l)
+}